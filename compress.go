@@ -2,24 +2,111 @@ package u
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// CompressionFormat describes compression format of a file
+type CompressionFormat int
+
+const (
+	CompressionNone CompressionFormat = iota
+	CompressionGzip
+	CompressionBzip2
+	CompressionZstd
+	CompressionXz
+	CompressionZlib
 )
 
+func (f CompressionFormat) String() string {
+	switch f {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionBzip2:
+		return "bzip2"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionXz:
+		return "xz"
+	case CompressionZlib:
+		return "zlib"
+	}
+	return fmt.Sprintf("CompressionFormat(%d)", int(f))
+}
+
+// number of bytes needed by DetectCompressionFormat (xz has the longest magic)
+const compressionSniffLen = 6
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXz   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// DetectCompressionFormat returns compression format based on magic bytes
+// at the beginning of d. Returns CompressionNone if d doesn't look compressed.
+// zlib is not detected because its 2-byte header is too easily matched
+// by plain text. Use CompressionFormatFromExt for zlib.
+func DetectCompressionFormat(d []byte) CompressionFormat {
+	if bytes.HasPrefix(d, magicGzip) {
+		return CompressionGzip
+	}
+	if bytes.HasPrefix(d, magicZstd) {
+		return CompressionZstd
+	}
+	if bytes.HasPrefix(d, magicXz) {
+		return CompressionXz
+	}
+	if len(d) >= 4 && d[0] == 'B' && d[1] == 'Z' && d[2] == 'h' && d[3] >= '1' && d[3] <= '9' {
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+// CompressionFormatFromExt returns compression format based on file extension
+func CompressionFormatFromExt(path string) CompressionFormat {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".gz", ".tgz":
+		return CompressionGzip
+	case ".bz2":
+		return CompressionBzip2
+	case ".zst":
+		return CompressionZstd
+	case ".xz":
+		return CompressionXz
+	case ".zz", ".zlib":
+		return CompressionZlib
+	}
+	return CompressionNone
+}
+
 // implement io.ReadCloser over os.File wrapped with io.Reader.
 // io.Closer goes to os.File, io.Reader goes to wrapping reader
+// (which is also closed if it implements io.Closer)
 type readerWrappedFile struct {
 	f *os.File
 	r io.Reader
 }
 
 func (rc *readerWrappedFile) Close() error {
+	if c, ok := rc.r.(io.Closer); ok {
+		c.Close()
+	}
 	return rc.f.Close()
 }
 
@@ -27,39 +114,107 @@ func (rc *readerWrappedFile) Read(p []byte) (int, error) {
 	return rc.r.Read(p)
 }
 
-// OpenFileMaybeCompressed opens a file that might be compressed with gzip
-// or bzip2.
-// TODO: could sniff file content instead of checking file extension
-func OpenFileMaybeCompressed(path string) (io.ReadCloser, error) {
-	ext := strings.ToLower(filepath.Ext(path))
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// readerWithCloser reads from a buffered reader and closes underlying reader
+// if it implements io.Closer
+type readerWithCloser struct {
+	*bufio.Reader
+	r io.Reader
+}
+
+func (rc *readerWithCloser) Close() error {
+	if c, ok := rc.r.(io.Closer); ok {
+		return c.Close()
 	}
-	if ext == ".gz" {
-		r, err := gzip.NewReader(f)
+	return nil
+}
+
+// newDecompressingReader returns a reader that decompresses r in a given format
+func newDecompressingReader(r io.Reader, format CompressionFormat) (io.Reader, error) {
+	switch format {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionBzip2:
+		return bzip2.NewReader(r), nil
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
 		if err != nil {
-			f.Close()
 			return nil, err
 		}
-		rc := &readerWrappedFile{
-			f: f,
-			r: r,
-		}
-		return rc, nil
+		return d.IOReadCloser(), nil
+	case CompressionXz:
+		return xz.NewReader(r)
+	case CompressionZlib:
+		return zlib.NewReader(r)
 	}
-	if ext == ".bz2" {
-		r := bzip2.NewReader(f)
-		rc := &readerWrappedFile{
-			f: f,
-			r: r,
+	return r, nil
+}
+
+// OpenFileMaybeCompressed opens a file that might be compressed with gzip,
+// bzip2, zstd, xz or zlib.
+func OpenFileMaybeCompressed(path string) (io.ReadCloser, error) {
+	rc, _, err := OpenFileMaybeCompressedFormat(path)
+	return rc, err
+}
+
+// OpenFileMaybeCompressedFormat is like OpenFileMaybeCompressed but also
+// returns detected compression format.
+// Format is detected by sniffing file content. If content doesn't match
+// any known format, we fall back to format implied by file extension.
+// If the data doesn't decode in that format, the file is returned as is.
+func OpenFileMaybeCompressedFormat(path string) (io.ReadCloser, CompressionFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	br := bufio.NewReader(f)
+	// Peek returns io.EOF for files shorter than compressionSniffLen
+	// which is fine as we only look at what we got
+	hdr, err := br.Peek(compressionSniffLen)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, CompressionNone, err
+	}
+	format := DetectCompressionFormat(hdr)
+	if format == CompressionNone {
+		// content didn't match known magic bytes (e.g. zstd skippable frame
+		// or zlib)
+		format = CompressionFormatFromExt(path)
+	}
+	if format != CompressionNone {
+		if r := tryDecompressingReader(br, format); r != nil {
+			return &readerWrappedFile{f: f, r: r}, format, nil
 		}
-		return rc, nil
 	}
-	return f, nil
+	// not compressed. decoder might have consumed some data so rewind
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, CompressionNone, err
+	}
+	return f, CompressionNone, nil
+}
+
+// tryDecompressingReader returns a reader decompressing r in a given format
+// or nil if the beginning of r doesn't decode. Magic bytes can match
+// plain data by accident
+func tryDecompressingReader(r io.Reader, format CompressionFormat) io.Reader {
+	dr, err := newDecompressingReader(r, format)
+	if err != nil {
+		return nil
+	}
+	br := bufio.NewReader(dr)
+	_, err = br.Peek(1)
+	if err == nil || err == io.EOF {
+		return &readerWithCloser{Reader: br, r: dr}
+	}
+	if c, ok := dr.(io.Closer); ok {
+		c.Close()
+	}
+	return nil
 }
 
-// ReadFileMaybeCompressed reads file. Ungzips if it's gzipped.
+// ReadFileMaybeCompressed reads file. Decompresses it if it's compressed
+// in one of the formats supported by OpenFileMaybeCompressed.
 func ReadFileMaybeCompressed(path string) ([]byte, error) {
 	r, err := OpenFileMaybeCompressed(path)
 	if err != nil {
@@ -69,23 +224,70 @@ func ReadFileMaybeCompressed(path string) ([]byte, error) {
 	return ioutil.ReadAll(r)
 }
 
-// WriteFileGzipped writes data to a path, using best gzip compression
-func WriteFileGzipped(path string, data []byte) error {
-	f, err := os.Create(path)
+// CompressionLevelDefault tells compressing functions to use default
+// compression level of a given format
+const CompressionLevelDefault = -1
+
+// xz doesn't have compression levels, only dictionary size
+// those match dictionary sizes of xz presets -0 ... -9
+var xzLevelDictCap = []int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// newCompressingWriter returns a writer that compresses to w in a given
+// format. The meaning of level depends on format:
+// gzip and zlib: 1 (best speed) to 9 (best compression)
+// zstd: 1 to 22, like zstd command-line tool
+// xz: 0 to 9, like xz command-line tool
+func newCompressingWriter(w io.Writer, format CompressionFormat, level int) (io.WriteCloser, error) {
+	switch format {
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZlib:
+		return zlib.NewWriterLevel(w, level)
+	case CompressionZstd:
+		if level == CompressionLevelDefault {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case CompressionXz:
+		if level == CompressionLevelDefault {
+			return xz.NewWriter(w)
+		}
+		if level < 0 || level >= len(xzLevelDictCap) {
+			return nil, fmt.Errorf("invalid xz compression level %d", level)
+		}
+		conf := xz.WriterConfig{
+			DictCap: xzLevelDictCap[level],
+		}
+		return conf.NewWriter(w)
+	}
+	return nil, fmt.Errorf("compression format %s is not supported for writing", format)
+}
+
+// CompressToWriter compresses data read from r and writes it to w
+func CompressToWriter(w io.Writer, r io.Reader, format CompressionFormat, level int) error {
+	cw, err := newCompressingWriter(w, format, level)
 	if err != nil {
 		return err
 	}
-	w, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+	_, err = io.Copy(cw, r)
 	if err != nil {
+		cw.Close()
 		return err
 	}
-	_, err = w.Write(data)
+	return cw.Close()
+}
+
+// compressToFile writes data from r compressed to path.
+// Removes the file on error
+func compressToFile(path string, r io.Reader, format CompressionFormat, level int) error {
+	f, err := os.Create(path)
 	if err != nil {
-		f.Close()
-		os.Remove(path)
 		return err
 	}
-	err = w.Close()
+	err = CompressToWriter(f, r, format, level)
 	if err != nil {
 		f.Close()
 		os.Remove(path)
@@ -99,27 +301,53 @@ func WriteFileGzipped(path string, data []byte) error {
 	return nil
 }
 
-// GzipFile compresses srcPath with gzip and saves as dstPath
-func GzipFile(dstPath, srcPath string) error {
+// WriteFileCompressed writes data to a path, compressed with a given format
+// and level (see newCompressingWriter for the meaning of level)
+func WriteFileCompressed(path string, data []byte, format CompressionFormat, level int) error {
+	return compressToFile(path, bytes.NewReader(data), format, level)
+}
+
+// CompressFile compresses srcPath with a given format and level
+// and saves as dstPath
+func CompressFile(dstPath, srcPath string, format CompressionFormat, level int) error {
 	fSrc, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
 	defer fSrc.Close()
-	fDst, err := os.Create(dstPath)
-	if err != nil {
-		return err
-	}
-	defer fDst.Close()
-	w, err := gzip.NewWriterLevel(fDst, gzip.BestCompression)
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(w, fSrc)
-	if err != nil {
-		return err
-	}
-	return w.Close()
+	return compressToFile(dstPath, fSrc, format, level)
+}
+
+// WriteFileGzipped writes data to a path, using best gzip compression
+func WriteFileGzipped(path string, data []byte) error {
+	return WriteFileCompressed(path, data, CompressionGzip, gzip.BestCompression)
+}
+
+// GzipFile compresses srcPath with gzip and saves as dstPath
+func GzipFile(dstPath, srcPath string) error {
+	return CompressFile(dstPath, srcPath, CompressionGzip, gzip.BestCompression)
+}
+
+// WriteFileZstd writes data to a path, compressed with zstd.
+// level is 1 to 22 (like zstd command-line tool) or CompressionLevelDefault
+func WriteFileZstd(path string, data []byte, level int) error {
+	return WriteFileCompressed(path, data, CompressionZstd, level)
+}
+
+// ZstdFile compresses srcPath with zstd and saves as dstPath
+func ZstdFile(dstPath, srcPath string, level int) error {
+	return CompressFile(dstPath, srcPath, CompressionZstd, level)
+}
+
+// WriteFileXz writes data to a path, compressed with xz.
+// level is 0 to 9 (like xz command-line tool) or CompressionLevelDefault
+func WriteFileXz(path string, data []byte, level int) error {
+	return WriteFileCompressed(path, data, CompressionXz, level)
+}
+
+// XzFile compresses srcPath with xz and saves as dstPath
+func XzFile(dstPath, srcPath string, level int) error {
+	return CompressFile(dstPath, srcPath, CompressionXz, level)
 }
 
 // CreateZipWithDirContent creates a zip file with the content of a directory.
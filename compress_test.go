@@ -31,3 +31,98 @@ func testGzip(t *testing.T, path string) {
 func TestGzip(t *testing.T) {
 	testGzip(t, "compress.go")
 }
+
+func TestWriteFileCompressed(t *testing.T) {
+	d, err := ioutil.ReadFile("compress.go")
+	assert.Nil(t, err)
+	tests := []struct {
+		format CompressionFormat
+		level  int
+	}{
+		{CompressionGzip, CompressionLevelDefault},
+		{CompressionZlib, 1},
+		{CompressionZstd, CompressionLevelDefault},
+		{CompressionZstd, 19},
+		{CompressionXz, CompressionLevelDefault},
+		{CompressionXz, 0},
+	}
+	for _, test := range tests {
+		path := "compress_test_write.bin"
+		if test.format == CompressionZlib {
+			path = "compress_test_write.zz"
+		}
+		err = WriteFileCompressed(path, d, test.format, test.level)
+		assert.Nil(t, err)
+		d2, err := ReadFileMaybeCompressed(path)
+		assert.Nil(t, err)
+		assert.Equal(t, d, d2, "format: %s, level: %d", test.format, test.level)
+		os.Remove(path)
+	}
+	err = WriteFileCompressed("compress_test_write.bz2", d, CompressionBzip2, CompressionLevelDefault)
+	assert.Error(t, err)
+}
+
+func TestOpenFileMaybeCompressedSniff(t *testing.T) {
+	d, err := ioutil.ReadFile("compress.go")
+	assert.Nil(t, err)
+
+	// gzipped file with extension that doesn't say so
+	path := "compress_test_sniff.log"
+	err = WriteFileGzipped(path, d)
+	assert.Nil(t, err)
+	defer os.Remove(path)
+	r, format, err := OpenFileMaybeCompressedFormat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionGzip, format)
+	d2, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, d, d2)
+
+	// plain file with .gz extension
+	path2 := "compress_test_sniff.txt.gz"
+	err = ioutil.WriteFile(path2, d, 0644)
+	assert.Nil(t, err)
+	defer os.Remove(path2)
+	r, format, err = OpenFileMaybeCompressedFormat(path2)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionNone, format)
+	d2, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, d, d2)
+}
+
+func TestOpenFileMaybeCompressedText(t *testing.T) {
+	// first 2 bytes of each look like zlib header, "BZh1" like bzip2
+	for _, s := range []string{"HKEY_LOCAL_MACHINE\n", "hCaptcha", "XGA", "(S)", "8O", "BZh1 not bzip2"} {
+		path := "compress_test_text.txt"
+		err := ioutil.WriteFile(path, []byte(s), 0644)
+		assert.Nil(t, err)
+		d, err := ReadFileMaybeCompressed(path)
+		os.Remove(path)
+		assert.Nil(t, err)
+		assert.Equal(t, s, string(d))
+	}
+}
+
+func TestDetectCompressionFormat(t *testing.T) {
+	tests := []struct {
+		d   []byte
+		exp CompressionFormat
+	}{
+		{nil, CompressionNone},
+		{[]byte("package u"), CompressionNone},
+		{[]byte{0x1f, 0x8b, 8, 0}, CompressionGzip},
+		{[]byte("BZh91AY"), CompressionBzip2},
+		{[]byte("BZx"), CompressionNone},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0}, CompressionZstd},
+		{[]byte{0xfd, '7', 'z', 'X', 'Z', 0}, CompressionXz},
+		// zlib is only detected by extension
+		{[]byte{0x78, 0x9c}, CompressionNone},
+	}
+	for _, test := range tests {
+		got := DetectCompressionFormat(test.d)
+		assert.Equal(t, test.exp, got, "%v", test.d)
+	}
+}
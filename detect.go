@@ -0,0 +1,162 @@
+package u
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// how much of the beginning of the file we look at to tell if it's
+// binary or generated
+const fileSniffSize = 32 * 1024
+
+// VendoredDirs are names of directories with third-party code
+var VendoredDirs = []string{"vendor", "node_modules", "third_party", "bower_components"}
+
+// https://golang.org/s/generatedcode
+var goGeneratedRx = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.\r?$`)
+
+// IsBinaryData returns true if d (usually the beginning of a file) looks
+// like binary data: has a NUL byte or more than 10% of bytes that are
+// not valid UTF-8 or are control characters
+func IsBinaryData(d []byte) bool {
+	if bytes.IndexByte(d, 0) >= 0 {
+		return true
+	}
+	nBad := 0
+	for i := 0; i < len(d); {
+		c := d[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != '\v' && c != 0x1b {
+				nBad++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(d[i:])
+		if r == utf8.RuneError && size == 1 {
+			// rune cut at the end of sniffed data is fine
+			if len(d)-i < utf8.UTFMax && !utf8.FullRune(d[i:]) {
+				break
+			}
+			nBad++
+		}
+		i += size
+	}
+	return nBad*10 > len(d)
+}
+
+func isMinifiedExt(ext string) bool {
+	switch ext {
+	case ".js", ".mjs", ".cjs", ".css":
+		return true
+	}
+	return false
+}
+
+// isMinified returns true if d, the beginning of .js or .css file, has
+// very long lines, which is typical for minified and bundled code
+func isMinified(d []byte) bool {
+	// too little data to tell
+	if len(d) < 1024 {
+		return false
+	}
+	nLines := bytes.Count(d, []byte{'\n'}) + 1
+	return len(d)/nLines > 250
+}
+
+// IsGeneratedData returns true if a file with a given path and content
+// (or the beginning of it) is generated: has Go's
+// "// Code generated ... DO NOT EDIT." comment or is minified .js or .css
+func IsGeneratedData(path string, d []byte) bool {
+	if goGeneratedRx.Match(d) {
+		return true
+	}
+	name := strings.ToLower(filepath.Base(path))
+	ext := filepath.Ext(name)
+	if !isMinifiedExt(ext) {
+		return false
+	}
+	if strings.HasSuffix(name, ".min"+ext) {
+		return true
+	}
+	return isMinified(d)
+}
+
+// IsVendoredPath returns true if any directory in path is one of VendoredDirs
+func IsVendoredPath(path string) bool {
+	return !MakeExcludeDirsFilter(VendoredDirs...)(path)
+}
+
+// readFileHead returns up to n bytes from the beginning of the file
+func readFileHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := make([]byte, n)
+	n, err = io.ReadFull(f, d)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return d[:n], nil
+}
+
+// IsBinaryFile returns true if the file looks like binary (see IsBinaryData)
+func IsBinaryFile(path string) (bool, error) {
+	d, err := readFileHead(path, fileSniffSize)
+	if err != nil {
+		return false, err
+	}
+	return IsBinaryData(d), nil
+}
+
+// IsGeneratedFile returns true if the file looks generated
+// (see IsGeneratedData)
+func IsGeneratedFile(path string) (bool, error) {
+	d, err := readFileHead(path, fileSniffSize)
+	if err != nil {
+		return false, err
+	}
+	return IsGeneratedData(path, d), nil
+}
+
+// makeFileContentFilter returns FilterFunc that rejects files for which
+// isBad returns true. Paths are joined with baseDir, so that it works
+// with relative paths. Paths that can't be read (e.g. directories)
+// are accepted
+func makeFileContentFilter(baseDir string, isBad func(path string) (bool, error)) FilterFunc {
+	return func(path string) bool {
+		if baseDir != "" {
+			path = filepath.Join(baseDir, path)
+		}
+		bad, err := isBad(path)
+		return err != nil || !bad
+	}
+}
+
+// MakeExcludeBinaryFilter returns FilterFunc that rejects binary files.
+// If baseDir is not empty, it's joined with paths given to the filter
+// (e.g. FileWalkOptions.FileFilter is called with paths relative to start
+// directory). Use "" with CalcInDir, which uses full paths
+func MakeExcludeBinaryFilter(baseDir string) FilterFunc {
+	return makeFileContentFilter(baseDir, IsBinaryFile)
+}
+
+// MakeExcludeGeneratedFilter returns FilterFunc that rejects generated
+// files and files in vendored directories (see VendoredDirs).
+// baseDir is used like in MakeExcludeBinaryFilter
+func MakeExcludeGeneratedFilter(baseDir string) FilterFunc {
+	return MakeFilterAnd(MakeExcludeVendoredFilter(), makeFileContentFilter(baseDir, IsGeneratedFile))
+}
+
+// MakeExcludeVendoredFilter returns FilterFunc that rejects files in
+// vendored directories (see VendoredDirs)
+func MakeExcludeVendoredFilter() FilterFunc {
+	return MakeExcludeDirsFilter(VendoredDirs...)
+}
@@ -0,0 +1,75 @@
+package u
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBinaryData(t *testing.T) {
+	assert.False(t, IsBinaryData(nil))
+	assert.False(t, IsBinaryData([]byte("hello\tworld\r\n")))
+	assert.False(t, IsBinaryData([]byte("zażółć gęślą jaźń")))
+	// utf-8 sequence cut at the end
+	assert.False(t, IsBinaryData([]byte("abcdef\xc5")))
+	assert.True(t, IsBinaryData([]byte("abc\x00def")))
+	assert.True(t, IsBinaryData([]byte("\xff\xfe\xfd\x01\x02abcdef")))
+}
+
+func TestIsGeneratedData(t *testing.T) {
+	goGen := "// Code generated by stringer; DO NOT EDIT.\n\npackage u\n"
+	assert.True(t, IsGeneratedData("a.go", []byte(goGen)))
+	assert.False(t, IsGeneratedData("a.go", []byte("package u\n// Code generated by hand\n")))
+	assert.True(t, IsGeneratedData("lib.min.js", []byte("x=1")))
+	minified := "var a=1;" + strings.Repeat("function f(){return 1};", 100)
+	assert.True(t, IsGeneratedData("bundle.js", []byte(minified)))
+	assert.False(t, IsGeneratedData("bundle.txt", []byte(minified)))
+	normal := strings.Repeat("function f() {\n  return 1;\n}\n", 100)
+	assert.False(t, IsGeneratedData("app.js", []byte(normal)))
+
+	assert.True(t, IsVendoredPath(filepath.Join("a", "node_modules", "x.js")))
+	assert.False(t, IsVendoredPath(filepath.Join("a", "vendors.js")))
+}
+
+func TestDetectFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-detect-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":           "package a\n",
+		"gen.go":         "// Code generated by go generate. DO NOT EDIT.\npackage a\n",
+		"data.txt":       "\x00\x01\x02",
+		"vendor/v.go":    "package v\n",
+		"sub/lib.min.js": "x=1",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	opts := &FileWalkOptions{
+		DirFilter:  MakeExcludeVendoredFilter(),
+		FileFilter: MakeFilterAnd(MakeExcludeBinaryFilter(dir), MakeExcludeGeneratedFilter(dir)),
+	}
+	ft := StartFileWalkWithOptions(context.Background(), dir, opts)
+	var got []string
+	for fte := range ft.FilesChan {
+		got = append(got, fte.FileInfo.Name())
+	}
+	assert.Equal(t, []string{"a.go"}, got)
+
+	lc, err := FileLineCountDetailed(filepath.Join(dir, "gen.go"))
+	assert.Nil(t, err)
+	assert.True(t, lc.Generated)
+	assert.False(t, lc.Binary)
+	assert.Equal(t, 3, lc.LineCount)
+	lc, err = FileLineCountDetailed(filepath.Join(dir, "data.txt"))
+	assert.Nil(t, err)
+	assert.True(t, lc.Binary)
+	assert.False(t, lc.Generated)
+}
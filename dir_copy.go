@@ -0,0 +1,149 @@
+package u
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// DirCopyProgress describes progress of DirCopyParallel
+type DirCopyProgress struct {
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// DirCopyOptions describes options for DirCopyParallel
+type DirCopyOptions struct {
+	// if set, only files for which ShouldCopy(srcPath) returns true
+	// are copied (same as shouldCopyFn in DirCopyRecur)
+	ShouldCopy func(path string) bool
+	// number of goroutines copying files. 0 means runtime.NumCPU()
+	Workers int
+	// if set, called after each copied file. Calls are serialized
+	Progress func(p DirCopyProgress)
+}
+
+type dirCopyFile struct {
+	dst  string
+	src  string
+	size int64
+}
+
+// DirCopyParallel is like DirCopyRecur but copies files using multiple
+// goroutines. Only regular files are copied.
+// Cancelling ctx stops copying (we check it between files) and returns
+// ctx.Err(). Returns a sorted list of copied files (source paths).
+// opts can be nil
+func DirCopyParallel(ctx context.Context, dstDir, srcDir string, opts *DirCopyOptions) ([]string, error) {
+	if opts == nil {
+		opts = &DirCopyOptions{}
+	}
+	nWorkers := opts.Workers
+	if nWorkers <= 0 {
+		nWorkers = runtime.NumCPU()
+	}
+
+	var files []*dirCopyFile
+	var progress DirCopyProgress
+	err := filepath.Walk(srcDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+		if fi.IsDir() {
+			return CreateDir(dst)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if opts.ShouldCopy != nil && !opts.ShouldCopy(path) {
+			return nil
+		}
+		f := &dirCopyFile{
+			dst:  dst,
+			src:  path,
+			size: fi.Size(),
+		}
+		files = append(files, f)
+		progress.FilesTotal++
+		progress.BytesTotal += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	var copied []string
+	fileDone := func(f *dirCopyFile, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			// stop other workers
+			cancel()
+			return
+		}
+		copied = append(copied, f.src)
+		progress.FilesDone++
+		progress.BytesDone += f.size
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	ch := make(chan *dirCopyFile)
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range ch {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := CopyFile(f.dst, f.src)
+				fileDone(f, err)
+			}
+		}()
+	}
+loop:
+	for _, f := range files {
+		select {
+		case ch <- f:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// ctx is our derived context so it could only be cancelled by a caller
+	// or by an error, which we've already handled
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(copied)
+	return copied, nil
+}
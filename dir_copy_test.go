@@ -0,0 +1,52 @@
+package u
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirCopyParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-copy-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("d%d/f%d.txt", i%3, i)
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(srcDir, name))
+		assert.Nil(t, err)
+	}
+	err = WriteFileCreateDirMust([]byte("skip"), filepath.Join(srcDir, "skip.log"))
+	assert.Nil(t, err)
+
+	var last DirCopyProgress
+	opts := &DirCopyOptions{
+		ShouldCopy: func(path string) bool {
+			return strings.HasSuffix(path, ".txt")
+		},
+		Workers: 4,
+		Progress: func(p DirCopyProgress) {
+			last = p
+		},
+	}
+	dstDir := filepath.Join(dir, "dst")
+	copied, err := DirCopyParallel(context.Background(), dstDir, srcDir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, len(copied))
+	assert.Equal(t, 20, last.FilesDone)
+	assert.Equal(t, last.BytesTotal, last.BytesDone)
+	assert.True(t, FileExists(filepath.Join(dstDir, "d1", "f1.txt")))
+	assert.False(t, FileExists(filepath.Join(dstDir, "skip.log")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DirCopyParallel(ctx, filepath.Join(dir, "dst2"), srcDir, nil)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,202 @@
+package u
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DirSyncOptions describes options for DirSync
+type DirSyncOptions struct {
+	// if set, only files for which ShouldCopy(srcPath) returns true
+	// are copied (same as shouldCopyFn in DirCopyRecur)
+	ShouldCopy func(path string) bool
+	// by default files are considered the same if they have the same size
+	// and modification time. If CompareHash is true, we compare sha1
+	// of the content
+	CompareHash bool
+	// if true, files and directories in dstDir that don't exist in srcDir
+	// (or are not copied because of ShouldCopy) are deleted. So are files
+	// where srcDir has a directory and directories where it has a file
+	Delete bool
+	// if true, only report what would be done
+	DryRun bool
+}
+
+// DirSyncReport describes what DirSync did (or would do if DryRun)
+type DirSyncReport struct {
+	// source paths of copied files
+	Copied []string
+	// source paths of files that were the same in dstDir
+	Skipped []string
+	// paths of files and directories deleted from dstDir
+	Deleted []string
+}
+
+// DirSync makes dstDir have the same files as srcDir, copying only files
+// that changed. Copied files preserve permissions and modification time.
+// Only regular files and directories are copied.
+// opts can be nil
+func DirSync(dstDir, srcDir string, opts *DirSyncOptions) (*DirSyncReport, error) {
+	if opts == nil {
+		opts = &DirSyncOptions{}
+	}
+	res := &DirSyncReport{}
+	err := dirSyncRecur(dstDir, srcDir, opts, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func dirSyncRecur(dstDir, srcDir string, opts *DirSyncOptions, res *DirSyncReport) error {
+	if !opts.DryRun {
+		err := CreateDir(dstDir)
+		if err != nil {
+			return err
+		}
+	}
+	fileInfos, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	// with DryRun dstDir might not exist or be a file that would be deleted
+	dstExists := DirExists(dstDir)
+	// names of files and dirs in dstDir that should stay
+	keep := map[string]bool{}
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		src := filepath.Join(srcDir, name)
+		dst := filepath.Join(dstDir, name)
+		if fi.IsDir() {
+			keep[name] = true
+			if dstExists {
+				err = dirSyncRemoveWrongType(dst, true, opts, res)
+				if err != nil {
+					return err
+				}
+			}
+			err = dirSyncRecur(dst, src, opts, res)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if opts.ShouldCopy != nil && !opts.ShouldCopy(src) {
+			continue
+		}
+		keep[name] = true
+		same := false
+		if dstExists {
+			err = dirSyncRemoveWrongType(dst, false, opts, res)
+			if err != nil {
+				return err
+			}
+			same, err = dirSyncSameFile(dst, src, fi, opts)
+			if err != nil {
+				return err
+			}
+		}
+		if same {
+			res.Skipped = append(res.Skipped, src)
+			continue
+		}
+		if !opts.DryRun {
+			// dst might be read-only because we preserve mode, so we
+			// can't overwrite it
+			err = os.Remove(dst)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			copyOpts := &CopyFileOptions{
+				PreserveMode:  true,
+				PreserveTimes: true,
+			}
+			err = CopyFileWithOptions(dst, src, copyOpts)
+			if err != nil {
+				return err
+			}
+		}
+		res.Copied = append(res.Copied, src)
+	}
+	if !opts.Delete {
+		return nil
+	}
+	if !dstExists {
+		return nil
+	}
+	dstInfos, err := ioutil.ReadDir(dstDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range dstInfos {
+		if keep[fi.Name()] {
+			continue
+		}
+		path := filepath.Join(dstDir, fi.Name())
+		if !opts.DryRun {
+			err = os.RemoveAll(path)
+			if err != nil {
+				return err
+			}
+		}
+		res.Deleted = append(res.Deleted, path)
+	}
+	return nil
+}
+
+// dirSyncRemoveWrongType deletes dst if it's a file and we want a directory
+// or the other way around. Only done if opts.Delete is set
+func dirSyncRemoveWrongType(dst string, wantDir bool, opts *DirSyncOptions, res *DirSyncReport) error {
+	if !opts.Delete {
+		return nil
+	}
+	fi, err := os.Lstat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() == wantDir {
+		return nil
+	}
+	if !opts.DryRun {
+		err = os.RemoveAll(dst)
+		if err != nil {
+			return err
+		}
+	}
+	res.Deleted = append(res.Deleted, dst)
+	return nil
+}
+
+// dirSyncSameFile returns true if dst exists and has the same content as src
+func dirSyncSameFile(dst, src string, srcInfo os.FileInfo, opts *DirSyncOptions) (bool, error) {
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !dstInfo.Mode().IsRegular() || dstInfo.Size() != srcInfo.Size() {
+		return false, nil
+	}
+	if !opts.CompareHash {
+		return dstInfo.ModTime().Equal(srcInfo.ModTime()), nil
+	}
+	h1, err := Sha1OfFile(src)
+	if err != nil {
+		return false, err
+	}
+	h2, err := Sha1OfFile(dst)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h1, h2), nil
+}
@@ -0,0 +1,117 @@
+package u
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-sync-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	dstDir := filepath.Join(dir, "dst")
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(srcDir, name))
+		assert.Nil(t, err)
+	}
+	res, err := DirSync(dstDir, srcDir, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res.Copied))
+	assert.Equal(t, 0, len(res.Skipped))
+
+	err = WriteFileCreateDirMust([]byte("stale"), filepath.Join(dstDir, "stale.txt"))
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("changed"), 0644)
+	assert.Nil(t, err)
+
+	opts := &DirSyncOptions{Delete: true, DryRun: true}
+	res, err = DirSync(dstDir, srcDir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(srcDir, "a.txt")}, res.Copied)
+	assert.Equal(t, []string{filepath.Join(dstDir, "stale.txt")}, res.Deleted)
+	assert.True(t, FileExists(filepath.Join(dstDir, "stale.txt")))
+
+	opts.DryRun = false
+	opts.CompareHash = true
+	res, err = DirSync(dstDir, srcDir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Copied))
+	assert.Equal(t, []string{filepath.Join(srcDir, "sub", "b.txt")}, res.Skipped)
+	assert.Equal(t, 1, len(res.Deleted))
+	assert.False(t, FileExists(filepath.Join(dstDir, "stale.txt")))
+	d, err := ioutil.ReadFile(filepath.Join(dstDir, "a.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", string(d))
+}
+
+func TestDirSyncReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-sync-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	dstDir := filepath.Join(dir, "dst")
+	src := filepath.Join(srcDir, "ro.txt")
+	dst := filepath.Join(dstDir, "ro.txt")
+	for _, s := range []string{"first", "second version"} {
+		err = WriteFileCreateDirMust([]byte(s), src)
+		assert.Nil(t, err)
+		err = os.Chmod(src, 0444)
+		assert.Nil(t, err)
+		res, err := DirSync(dstDir, srcDir, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{src}, res.Copied)
+		d, err := ioutil.ReadFile(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, s, string(d))
+		fi, err := os.Stat(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0444), fi.Mode().Perm())
+		// so that we can overwrite it
+		err = os.Chmod(src, 0644)
+		assert.Nil(t, err)
+	}
+}
+
+func TestDirSyncTypeChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-sync-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	dstDir := filepath.Join(dir, "dst")
+	// x is a dir in src and a file in dst, y is the other way around
+	for _, name := range []string{"x/a.txt", "y"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(srcDir, name))
+		assert.Nil(t, err)
+	}
+	for _, name := range []string{"x", "y/b.txt"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(dstDir, name))
+		assert.Nil(t, err)
+	}
+	exp := []string{filepath.Join(dstDir, "x"), filepath.Join(dstDir, "y")}
+
+	opts := &DirSyncOptions{Delete: true, DryRun: true}
+	res, err := DirSync(dstDir, srcDir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, res.Deleted)
+	assert.Equal(t, 2, len(res.Copied))
+
+	opts.DryRun = false
+	res, err = DirSync(dstDir, srcDir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, res.Deleted)
+	assert.Equal(t, 2, len(res.Copied))
+	d, err := ioutil.ReadFile(filepath.Join(dstDir, "x", "a.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "x/a.txt", string(d))
+	d, err = ioutil.ReadFile(filepath.Join(dstDir, "y"))
+	assert.Nil(t, err)
+	assert.Equal(t, "y", string(d))
+}
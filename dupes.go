@@ -0,0 +1,255 @@
+package u
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// size of the beginning of the file hashed to quickly tell apart files
+// of the same size
+const dupePartialHashSize = 4 * 1024
+
+// FindDuplicatesOptions describes options for FindDuplicateFiles
+type FindDuplicatesOptions struct {
+	// if set, only files for which Filter(path) returns true are checked
+	Filter FilterFunc
+	// files smaller than that are ignored. 0 means only empty files
+	// are ignored
+	MinSize int64
+	// number of goroutines hashing files. 0 means runtime.NumCPU()
+	Workers int
+}
+
+// DuplicateGroup is a group of files with identical content
+type DuplicateGroup struct {
+	Size int64
+	// sorted
+	Paths []string
+}
+
+// Duplicates is a result of FindDuplicateFiles
+type Duplicates struct {
+	// sorted by size, largest first
+	Groups []*DuplicateGroup
+	// number of bytes that could be reclaimed by keeping only
+	// one file from each group
+	ReclaimableBytes int64
+}
+
+// sha1OfFilePrefix returns sha1 of the first n bytes of the file
+func sha1OfFilePrefix(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha1.New()
+	_, err = io.CopyN(h, f, n)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashFilesParallel calls hashFn for each path using nWorkers goroutines
+// and returns path => hash
+func hashFilesParallel(paths []string, nWorkers int, hashFn func(path string) ([]byte, error)) (map[string]string, error) {
+	res := map[string]string{}
+	var mu sync.Mutex
+	var firstErr error
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range ch {
+				h, err := hashFn(path)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				res[path] = string(h)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		ch <- path
+	}
+	close(ch)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return res, nil
+}
+
+// splitByHash splits each group into groups of files with the same hash,
+// dropping files with unique hash
+func splitByHash(groups [][]string, nWorkers int, hashFn func(path string) ([]byte, error)) ([][]string, error) {
+	var all []string
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	hashes, err := hashFilesParallel(all, nWorkers, hashFn)
+	if err != nil {
+		return nil, err
+	}
+	var res [][]string
+	for _, g := range groups {
+		byHash := map[string][]string{}
+		for _, path := range g {
+			h := hashes[path]
+			byHash[h] = append(byHash[h], path)
+		}
+		for _, paths := range byHash {
+			if len(paths) > 1 {
+				res = append(res, paths)
+			}
+		}
+	}
+	return res, nil
+}
+
+// FindDuplicateFiles finds files with identical content in dirs.
+// Files are grouped by size, then by sha1 of the beginning of the file
+// and then by sha1 of the whole file, so that we only read whole files
+// that are likely to be duplicates.
+// Files that are already hard links to each other are counted only once.
+// opts can be nil
+func FindDuplicateFiles(dirs []string, opts *FindDuplicatesOptions) (*Duplicates, error) {
+	if opts == nil {
+		opts = &FindDuplicatesOptions{}
+	}
+	nWorkers := opts.Workers
+	if nWorkers <= 0 {
+		nWorkers = runtime.NumCPU()
+	}
+	bySize := map[int64][]*FileWalkEntry{}
+	for _, dir := range dirs {
+		ft := StartFileWalkContext(context.Background(), dir)
+		for fte := range ft.FilesChan {
+			if fte.Err != nil {
+				ft.Stop()
+				return nil, fte.Err
+			}
+			size := fte.FileInfo.Size()
+			if size == 0 || size < opts.MinSize {
+				continue
+			}
+			if opts.Filter != nil && !opts.Filter(fte.Path()) {
+				continue
+			}
+			bySize[size] = append(bySize[size], fte)
+		}
+	}
+
+	// small files are fully read by partial hash so we hash them only once
+	var small, large [][]string
+	for size, entries := range bySize {
+		paths := uniqueFiles(entries)
+		if len(paths) < 2 {
+			continue
+		}
+		if size <= dupePartialHashSize {
+			small = append(small, paths)
+		} else {
+			large = append(large, paths)
+		}
+	}
+	small, err := splitByHash(small, nWorkers, Sha1OfFile)
+	if err != nil {
+		return nil, err
+	}
+	large, err = splitByHash(large, nWorkers, func(path string) ([]byte, error) {
+		return sha1OfFilePrefix(path, dupePartialHashSize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	large, err = splitByHash(large, nWorkers, Sha1OfFile)
+	if err != nil {
+		return nil, err
+	}
+	candidates := append(small, large...)
+
+	res := &Duplicates{}
+	for _, paths := range candidates {
+		sort.Strings(paths)
+		size, err := GetFileSize(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		g := &DuplicateGroup{
+			Size:  size,
+			Paths: paths,
+		}
+		res.Groups = append(res.Groups, g)
+		res.ReclaimableBytes += size * int64(len(paths)-1)
+	}
+	sort.Slice(res.Groups, func(i, j int) bool {
+		g1, g2 := res.Groups[i], res.Groups[j]
+		if g1.Size != g2.Size {
+			return g1.Size > g2.Size
+		}
+		return g1.Paths[0] < g2.Paths[0]
+	})
+	return res, nil
+}
+
+// uniqueFiles returns paths of entries, skipping files that are hard links
+// to a file that is already in the list (or the same file visited twice)
+func uniqueFiles(entries []*FileWalkEntry) []string {
+	var res []string
+	var infos []os.FileInfo
+	for _, e := range entries {
+		isDupe := false
+		for _, fi := range infos {
+			if os.SameFile(fi, e.FileInfo) {
+				isDupe = true
+				break
+			}
+		}
+		if !isDupe {
+			infos = append(infos, e.FileInfo)
+			res = append(res, e.Path())
+		}
+	}
+	return res
+}
+
+// HardLinkDuplicates replaces all but the first file in each group
+// with a hard link to the first file. Files must be on the same filesystem.
+// Each file is replaced atomically
+func HardLinkDuplicates(groups []*DuplicateGroup) error {
+	for _, g := range groups {
+		if len(g.Paths) < 2 {
+			continue
+		}
+		src := g.Paths[0]
+		for _, dst := range g.Paths[1:] {
+			// link to a temporary name and rename over dst so that dst
+			// always exists
+			tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.tmp-link", filepath.Base(dst)))
+			os.Remove(tmp)
+			err := os.Link(src, tmp)
+			if err != nil {
+				return err
+			}
+			err = os.Rename(tmp, dst)
+			if err != nil {
+				os.Remove(tmp)
+				return err
+			}
+		}
+	}
+	return nil
+}
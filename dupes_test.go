@@ -0,0 +1,65 @@
+package u
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDuplicateFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-dupes-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a/1.txt": "same content",
+		"b/2.txt": "same content",
+		"b/3.txt": "same content",
+		// same size, different content
+		"c/4.txt": "diff content",
+		"c/5.txt": "unique",
+		"c/empty": "",
+		"d/empty": "",
+		// larger than dupePartialHashSize, same beginning
+		"e/big1": strings.Repeat("x", 5000),
+		"e/big2": strings.Repeat("x", 5000),
+		"e/big3": strings.Repeat("x", 4999) + "y",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	dirs := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c"), filepath.Join(dir, "d"), filepath.Join(dir, "e")}
+	res, err := FindDuplicateFiles(dirs, &FindDuplicatesOptions{Workers: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res.Groups))
+	expBig := []string{
+		filepath.Join(dir, "e", "big1"),
+		filepath.Join(dir, "e", "big2"),
+	}
+	assert.Equal(t, expBig, res.Groups[0].Paths)
+	exp := []string{
+		filepath.Join(dir, "a", "1.txt"),
+		filepath.Join(dir, "b", "2.txt"),
+		filepath.Join(dir, "b", "3.txt"),
+	}
+	assert.Equal(t, exp, res.Groups[1].Paths)
+	assert.Equal(t, int64(5000+24), res.ReclaimableBytes)
+
+	err = HardLinkDuplicates(res.Groups)
+	assert.Nil(t, err)
+	fi1, err := os.Stat(exp[0])
+	assert.Nil(t, err)
+	fi2, err := os.Stat(exp[2])
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(fi1, fi2))
+
+	// hard linked files are not duplicates anymore
+	res, err = FindDuplicateFiles(dirs, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res.Groups))
+}
@@ -162,21 +162,10 @@ func RemoveFileLogged(path string) {
 }
 
 // CopyFile copies a file from src to dst
+// Doesn't preserve permissions or modification time, use
+// CopyFileWithOptions for that
 func CopyFile(dst, src string) error {
-	fsrc, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer fsrc.Close()
-	fdst, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer fdst.Close()
-	if _, err = io.Copy(fdst, fsrc); err != nil {
-		return err
-	}
-	return nil
+	return CopyFileWithOptions(dst, src, nil)
 }
 
 // CopyFileMust copies a file from src to dst
@@ -358,7 +347,10 @@ func DirCopyRecur(dstDir, srcDir string, shouldCopyFn func(path string) bool) ([
 		if !shouldCopy {
 			continue
 		}
-		CopyFileMust(dst, src)
+		err = CopyFile(dst, src)
+		if err != nil {
+			return nil, err
+		}
 		allCopied = append(allCopied, src)
 	}
 	return allCopied, nil
@@ -0,0 +1,115 @@
+package u
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomically creates a file at path with content written by fn.
+// The content is written to a temporary file in the same directory which
+// gets perm, is fsync'ed and renamed to path (after which the directory
+// is fsync'ed), so readers never see partially written file or a file
+// with wrong permissions.
+// If fn returns an error, path is not modified.
+func WriteAtomically(path string, perm os.FileMode, fn func(w io.Writer) error) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	renamed := false
+	defer func() {
+		// also runs if fn panics
+		if !renamed {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	err = fn(f)
+	if err != nil {
+		return err
+	}
+	// temporary files are created with 0600
+	err = f.Chmod(perm)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	renamed = true
+	// make the rename durable. not supported on all platforms so we
+	// ignore errors
+	if fdir, err := os.Open(dir); err == nil {
+		fdir.Sync()
+		fdir.Close()
+	}
+	return nil
+}
+
+// WriteFileAtomic is like ioutil.WriteFile() but writes the file atomically
+// (see WriteAtomically)
+func WriteFileAtomic(path string, data []byte) error {
+	return WriteAtomically(path, 0644, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteFileAtomicMust is like WriteFileMust but writes the file atomically
+func WriteFileAtomicMust(path string, data []byte) {
+	err := WriteFileAtomic(path, data)
+	Must(err)
+}
+
+// WriteFileCreateDirAtomic is like WriteFileAtomic but also creates
+// intermediary directories
+func WriteFileCreateDirAtomic(path string, data []byte) error {
+	if err := CreateDirForFile(path); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// WriteFileGzippedAtomic is like WriteFileGzipped but writes the file
+// atomically
+func WriteFileGzippedAtomic(path string, data []byte) error {
+	return WriteAtomically(path, 0644, func(w io.Writer) error {
+		return CompressToWriter(w, bytes.NewReader(data), CompressionGzip, gzip.BestCompression)
+	})
+}
+
+// CopyFileAtomic is like CopyFile but creates dst atomically.
+// dst gets the same permissions as src
+func CopyFileAtomic(dst, src string) error {
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+	st, err := fsrc.Stat()
+	if err != nil {
+		return err
+	}
+	return WriteAtomically(dst, st.Mode().Perm(), func(w io.Writer) error {
+		_, err := io.Copy(w, fsrc)
+		return err
+	})
+}
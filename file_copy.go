@@ -0,0 +1,69 @@
+package u
+
+import (
+	"io"
+	"os"
+)
+
+// CopyFileOptions describes which metadata CopyFileWithOptions preserves
+type CopyFileOptions struct {
+	// if true, dst gets permission bits of src
+	PreserveMode bool
+	// if true, dst gets modification time of src
+	PreserveTimes bool
+	// if true, copies extended attributes (only supported on Linux)
+	PreserveXattrs bool
+}
+
+// CopyFileWithOptions copies a file from src to dst, optionally preserving
+// metadata. On Linux it tries to create a reflink (copy-on-write clone)
+// or copy in the kernel with copy_file_range before doing regular copy.
+// opts can be nil
+func CopyFileWithOptions(dst, src string, opts *CopyFileOptions) error {
+	if opts == nil {
+		opts = &CopyFileOptions{}
+	}
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+	st, err := fsrc.Stat()
+	if err != nil {
+		return err
+	}
+	fdst, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = copyFileContent(fdst, fsrc, st)
+	if err == nil && opts.PreserveMode {
+		err = fdst.Chmod(st.Mode().Perm())
+	}
+	if err == nil && opts.PreserveXattrs {
+		err = copyXattrs(fdst, fsrc)
+	}
+	errClose := fdst.Close()
+	if err != nil {
+		return err
+	}
+	if errClose != nil {
+		return errClose
+	}
+	if opts.PreserveTimes {
+		mtime := st.ModTime()
+		return os.Chtimes(dst, mtime, mtime)
+	}
+	return nil
+}
+
+// copyFileContent copies content of src (whose FileInfo is st) to dst,
+// using fast platform-specific way if possible
+func copyFileContent(dst, src *os.File, st os.FileInfo) error {
+	done, err := copyFileFast(dst, src, st)
+	if err != nil || done {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
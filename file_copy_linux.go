@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package u
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileFast tries to clone src into dst (supported by btrfs, xfs etc.)
+// and then to copy with copy_file_range. Returns false if neither works
+// and the caller should copy the data itself.
+// st is FileInfo of src
+func copyFileFast(dst, src *os.File, st os.FileInfo) (bool, error) {
+	size := st.Size()
+	// FIFOs and files in /proc or /sys report size 0 but have content
+	// that can only be read
+	if size == 0 || !st.Mode().IsRegular() {
+		return false, nil
+	}
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if err == nil {
+		return true, nil
+	}
+	var copied int64
+	for copied < size {
+		toCopy := size - copied
+		// copy_file_range can't copy more than that in one call
+		if toCopy > 1<<30 {
+			toCopy = 1 << 30
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(toCopy), 0)
+		if err != nil {
+			if copied == 0 {
+				// not supported e.g. across filesystems on older kernels
+				return false, nil
+			}
+			return true, err
+		}
+		if n == 0 {
+			// file was truncated while we were copying
+			break
+		}
+		copied += int64(n)
+	}
+	return copied == size, nil
+}
+
+func copyXattrs(dst, src *os.File) error {
+	size, err := unix.Flistxattr(int(src.Fd()), nil)
+	if err != nil || size == 0 {
+		if err == unix.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Flistxattr(int(src.Fd()), buf)
+	if err != nil {
+		return err
+	}
+	// names are separated with 0
+	for _, name := range splitXattrNames(buf[:size]) {
+		n, err := unix.Fgetxattr(int(src.Fd()), name, nil)
+		if err != nil {
+			return err
+		}
+		val := make([]byte, n)
+		n, err = unix.Fgetxattr(int(src.Fd()), name, val)
+		if err != nil {
+			return err
+		}
+		err = unix.Fsetxattr(int(dst.Fd()), name, val[:n], 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitXattrNames(d []byte) []string {
+	var res []string
+	start := 0
+	for i, c := range d {
+		if c == 0 {
+			if i > start {
+				res = append(res, string(d[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return res
+}
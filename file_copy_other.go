@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package u
+
+import (
+	"errors"
+	"os"
+)
+
+func copyFileFast(dst, src *os.File, st os.FileInfo) (bool, error) {
+	return false, nil
+}
+
+func copyXattrs(dst, src *os.File) error {
+	return errors.New("copying extended attributes is only supported on Linux")
+}
@@ -1,7 +1,13 @@
 package u
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,3 +23,82 @@ func TestFile(t *testing.T) {
 	assert.Equal(t, 3, len(lines))
 	assert.Equal(t, "line 1", lines[0])
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-atomic-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sub", "state.txt")
+	err = WriteFileCreateDirAtomic(path, []byte("v1"))
+	assert.Nil(t, err)
+	err = WriteAtomically(path, 0644, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	d, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(d))
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+	// temporary file must be removed
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	// mode is set on the temporary file, before it replaces path
+	err = WriteAtomically(path, 0755, func(w io.Writer) error {
+		_, err := w.Write([]byte("v2"))
+		return err
+	})
+	assert.Nil(t, err)
+	fi, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+}
+
+func TestCopyFileWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-copy-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "run.sh")
+	err = ioutil.WriteFile(src, []byte("#!/bin/sh\n"), 0755)
+	assert.Nil(t, err)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = os.Chtimes(src, mtime, mtime)
+	assert.Nil(t, err)
+
+	dst := filepath.Join(dir, "run2.sh")
+	opts := &CopyFileOptions{
+		PreserveMode:  true,
+		PreserveTimes: true,
+	}
+	err = CopyFileWithOptions(dst, src, opts)
+	assert.Nil(t, err)
+	assert.True(t, AreFilesEuqalMust(src, dst))
+	fi, err := os.Stat(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime))
+}
+
+func TestCopyFileZeroSize(t *testing.T) {
+	// files in /proc report size 0 but have content
+	src := "/proc/version"
+	if !FileExists(src) {
+		t.Skip("no /proc")
+	}
+	dir, err := ioutil.TempDir("", "u-copy-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "version")
+	err = CopyFile(dst, src)
+	assert.Nil(t, err)
+	d, err := ioutil.ReadFile(dst)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, d)
+}
@@ -1,9 +1,11 @@
 package u
 
 import (
-	"io/ioutil"
+	"context"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -11,6 +13,9 @@ import (
 type FileWalkEntry struct {
 	Dir      string
 	FileInfo os.FileInfo
+	// Err is set if reading directory Dir failed. FileInfo is nil in that case.
+	// Not sent by StartFileWalk
+	Err error
 }
 
 // Path returns full path of the file
@@ -18,11 +23,53 @@ func (e *FileWalkEntry) Path() string {
 	return filepath.Join(e.Dir, e.FileInfo.Name())
 }
 
+// FileWalkOptions describes options for StartFileWalkWithOptions
+type FileWalkOptions struct {
+	// if set, directories for which DirFilter returns false are not visited.
+	// It's called with path relative to start directory, ending with path
+	// separator, so that it works with MakeExcludeDirsFilter
+	DirFilter FilterFunc
+	// if set, only files for which FileFilter returns true are sent.
+	// It's called with path relative to start directory
+	FileFilter FilterFunc
+	// if > 0, limits how deep we go. Files in start directory have depth 1
+	MaxDepth int
+	// if true, symlinks are followed. Directories that were already
+	// visited (e.g. due to symlink cycle) are skipped
+	FollowSymlinks bool
+	// if true, entries for directories are sent as well
+	EmitDirs bool
+	// number of goroutines reading directories. 0 means runtime.NumCPU()
+	Workers int
+	// if true, files and directories ignored by .gitignore files
+	// (see GitIgnore) are skipped
+	RespectGitIgnore bool
+}
+
 // FileWalk describes a file traversal
 type FileWalk struct {
 	startDir    string
 	FilesChan   chan *FileWalkEntry
 	askedToStop int32
+	ctx         context.Context
+	opts        *FileWalkOptions
+	sendErrors  bool
+
+	// protects fields below
+	mu   sync.Mutex
+	cond *sync.Cond
+	// directories to visit, shared by all workers
+	toVisit []fileWalkDir
+	// number of directories in toVisit or being read by a worker
+	pending int
+	stopped bool
+	// real paths of visited directories, if following symlinks
+	visited map[string]bool
+}
+
+type fileWalkDir struct {
+	path  string
+	depth int
 }
 
 // Stop stops file traversal
@@ -33,49 +80,265 @@ func (ft *FileWalk) Stop() {
 	}
 }
 
-func fileWalkWorker(ft *FileWalk) {
-	toVisit := []string{ft.startDir}
-	defer close(ft.FilesChan)
+func (ft *FileWalk) shouldStop() bool {
+	return atomic.LoadInt32(&ft.askedToStop) > 0 || ft.ctx.Err() != nil
+}
 
-	for len(toVisit) > 0 {
-		shouldStop := atomic.LoadInt32(&ft.askedToStop)
-		if shouldStop > 0 {
-			return
+// send sends fte to FilesChan. Returns false if the traversal was cancelled
+func (ft *FileWalk) send(fte *FileWalkEntry) bool {
+	select {
+	case ft.FilesChan <- fte:
+		return true
+	case <-ft.ctx.Done():
+		return false
+	}
+}
+
+// relPath returns path relative to start directory, for filters
+func (ft *FileWalk) relPath(path string) string {
+	rel, err := filepath.Rel(ft.startDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// shouldVisitDir returns false if dir was pruned or already visited
+func (ft *FileWalk) shouldVisitDir(path string) bool {
+	if ft.opts.DirFilter != nil {
+		if !ft.opts.DirFilter(ft.relPath(path) + string(filepath.Separator)) {
+			return false
 		}
-		// would be more efficient to shift by one and
-		// chop off at the end
-		dir := toVisit[0]
-		toVisit = StringsRemoveFirst(toVisit)
-
-		files, err := ioutil.ReadDir(dir)
-		// TODO: should I send errors as well?
-		if err != nil {
-			continue
+	}
+	if ft.visited == nil {
+		return true
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.visited[realPath] {
+		return false
+	}
+	ft.visited[realPath] = true
+	return true
+}
+
+// nextDir returns next directory to visit, waiting if other workers
+// might add more. Returns false when there's nothing left to do
+func (ft *FileWalk) nextDir() (fileWalkDir, bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for len(ft.toVisit) == 0 && ft.pending > 0 && !ft.stopped {
+		ft.cond.Wait()
+	}
+	if ft.stopped || len(ft.toVisit) == 0 {
+		return fileWalkDir{}, false
+	}
+	// taking from the end is depth-first which keeps the queue short
+	n := len(ft.toVisit) - 1
+	dir := ft.toVisit[n]
+	ft.toVisit = ft.toVisit[:n]
+	return dir, true
+}
+
+func (ft *FileWalk) addDirs(dirs []fileWalkDir) {
+	if len(dirs) == 0 {
+		return
+	}
+	ft.mu.Lock()
+	ft.toVisit = append(ft.toVisit, dirs...)
+	ft.pending += len(dirs)
+	ft.mu.Unlock()
+	ft.cond.Broadcast()
+}
+
+// dirDone marks a directory as visited. If stop is true, tells all
+// workers to stop
+func (ft *FileWalk) dirDone(stop bool) {
+	ft.mu.Lock()
+	ft.pending--
+	if stop {
+		ft.stopped = true
+	}
+	wake := ft.pending == 0 || ft.stopped
+	ft.mu.Unlock()
+	if wake {
+		ft.cond.Broadcast()
+	}
+}
+
+// readDir reads entries in directory (unsorted, which is faster than
+// os.ReadDir) and sends files. Returns subdirectories to visit and false
+// if the traversal was cancelled
+func (ft *FileWalk) readDir(dir fileWalkDir) ([]fileWalkDir, bool) {
+	opts := ft.opts
+	f, err := os.Open(dir.path)
+	var entries []os.DirEntry
+	if err == nil {
+		entries, err = f.ReadDir(-1)
+		f.Close()
+	}
+	if err != nil {
+		if ft.sendErrors {
+			fte := &FileWalkEntry{
+				Dir: dir.path,
+				Err: err,
+			}
+			return nil, ft.send(fte)
 		}
-		for _, fi := range files {
-			path := filepath.Join(dir, fi.Name())
-			mode := fi.Mode()
-			if mode.IsDir() {
-				toVisit = append(toVisit, path)
-			} else if mode.IsRegular() {
+		return nil, true
+	}
+	var subDirs []fileWalkDir
+	depth := dir.depth + 1
+	for _, de := range entries {
+		path := filepath.Join(dir.path, de.Name())
+		typ := de.Type()
+		// FileInfo for followed symlink
+		var fi os.FileInfo
+		if opts.FollowSymlinks && typ&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(path); err != nil {
+				continue
+			}
+			typ = fi.Mode().Type()
+		}
+		if typ.IsDir() {
+			if !ft.shouldVisitDir(path) {
+				continue
+			}
+			if opts.EmitDirs {
+				if fi == nil {
+					if fi, err = de.Info(); err != nil {
+						continue
+					}
+				}
 				fte := &FileWalkEntry{
-					Dir:      dir,
+					Dir:      dir.path,
 					FileInfo: fi,
 				}
-				ft.FilesChan <- fte
+				if !ft.send(fte) {
+					return nil, false
+				}
+			}
+			if opts.MaxDepth <= 0 || depth < opts.MaxDepth {
+				subDirs = append(subDirs, fileWalkDir{path, depth})
+			}
+			continue
+		}
+		if !typ.IsRegular() {
+			continue
+		}
+		// filter before Info() to avoid a stat call for skipped files
+		if opts.FileFilter != nil && !opts.FileFilter(ft.relPath(path)) {
+			continue
+		}
+		if fi == nil {
+			// file might have been deleted since we read the directory
+			if fi, err = de.Info(); err != nil {
+				continue
 			}
 		}
+		fte := &FileWalkEntry{
+			Dir:      dir.path,
+			FileInfo: fi,
+		}
+		if !ft.send(fte) {
+			return nil, false
+		}
 	}
+	return subDirs, true
 }
 
-// StartFileWalk starts a file traversal from startDir
-func StartFileWalk(startDir string) *FileWalk {
-	// buffered channel so that
+func fileWalkWorker(ft *FileWalk) {
+	for {
+		dir, ok := ft.nextDir()
+		if !ok {
+			return
+		}
+		if ft.shouldStop() {
+			ft.dirDone(true)
+			return
+		}
+		subDirs, ok := ft.readDir(dir)
+		ft.addDirs(subDirs)
+		ft.dirDone(!ok)
+	}
+}
+
+func startFileWalk(ctx context.Context, startDir string, opts *FileWalkOptions, sendErrors bool) *FileWalk {
+	if opts == nil {
+		opts = &FileWalkOptions{}
+	}
+	if opts.RespectGitIgnore {
+		// don't modify caller's options
+		o := *opts
+		filter := NewGitIgnore(startDir).relFilter()
+		if o.DirFilter != nil {
+			o.DirFilter = MakeFilterAnd(filter, o.DirFilter)
+		} else {
+			o.DirFilter = filter
+		}
+		if o.FileFilter != nil {
+			o.FileFilter = MakeFilterAnd(filter, o.FileFilter)
+		} else {
+			o.FileFilter = filter
+		}
+		opts = &o
+	}
+	nWorkers := opts.Workers
+	if nWorkers <= 0 {
+		nWorkers = runtime.NumCPU()
+	}
+	// buffered channel so that workers don't wait for the consumer
 	ch := make(chan *FileWalkEntry, 1024*64)
 	ft := &FileWalk{
-		startDir:  startDir,
-		FilesChan: ch,
+		startDir:   startDir,
+		FilesChan:  ch,
+		ctx:        ctx,
+		opts:       opts,
+		sendErrors: sendErrors,
+		toVisit:    []fileWalkDir{{startDir, 0}},
+		pending:    1,
+	}
+	ft.cond = sync.NewCond(&ft.mu)
+	if opts.FollowSymlinks {
+		ft.visited = map[string]bool{}
+		ft.shouldVisitDir(startDir)
 	}
-	go fileWalkWorker(ft)
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			fileWalkWorker(ft)
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ft.FilesChan)
+	}()
 	return ft
 }
+
+// StartFileWalk starts a file traversal from startDir
+// Directories that can't be read are silently skipped
+func StartFileWalk(startDir string) *FileWalk {
+	return startFileWalk(context.Background(), startDir, nil, false)
+}
+
+// StartFileWalkContext starts a file traversal from startDir.
+// Unlike StartFileWalk, directories that can't be read are reported
+// as entries with Err set.
+// Cancelling ctx stops the traversal and closes FilesChan
+func StartFileWalkContext(ctx context.Context, startDir string) *FileWalk {
+	return startFileWalk(ctx, startDir, nil, true)
+}
+
+// StartFileWalkWithOptions is like StartFileWalkContext but allows
+// pruning directories, filtering files, limiting depth and following
+// symlinks. opts can be nil
+func StartFileWalkWithOptions(ctx context.Context, startDir string, opts *FileWalkOptions) *FileWalk {
+	return startFileWalk(ctx, startDir, opts, true)
+}
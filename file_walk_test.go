@@ -0,0 +1,89 @@
+package u
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWalkContext(t *testing.T) {
+	ft := StartFileWalkContext(context.Background(), "dir_that_doesnt_exist")
+	var errs []error
+	for fte := range ft.FilesChan {
+		if fte.Err != nil {
+			errs = append(errs, fte.Err)
+		}
+	}
+	assert.Equal(t, 1, len(errs))
+
+	ft = StartFileWalkContext(context.Background(), ".")
+	nFiles := 0
+	for fte := range ft.FilesChan {
+		assert.Nil(t, fte.Err)
+		if fte.FileInfo.Name() == "file_walk.go" {
+			nFiles++
+		}
+	}
+	assert.Equal(t, 1, nFiles)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ft = StartFileWalkContext(ctx, ".")
+	for range ft.FilesChan {
+	}
+}
+
+func TestFileWalkWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-walk-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.go", "a.txt", "sub/b.go", "sub/deep/c.go", "node_modules/d.go"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	// symlink cycle
+	err = os.Symlink("..", filepath.Join(dir, "sub", "up"))
+	assert.Nil(t, err)
+
+	walk := func(opts *FileWalkOptions) []string {
+		var res []string
+		ft := StartFileWalkWithOptions(context.Background(), dir, opts)
+		for fte := range ft.FilesChan {
+			assert.Nil(t, fte.Err)
+			rel, _ := filepath.Rel(dir, fte.Path())
+			res = append(res, filepath.ToSlash(rel))
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	opts := &FileWalkOptions{
+		DirFilter:  MakeExcludeDirsFilter("node_modules"),
+		FileFilter: MakeAllowedFileFilterForExts(".go"),
+	}
+	assert.Equal(t, []string{"a.go", "sub/b.go", "sub/deep/c.go"}, walk(opts))
+
+	opts.MaxDepth = 2
+	opts.EmitDirs = true
+	assert.Equal(t, []string{"a.go", "sub", "sub/b.go", "sub/deep"}, walk(opts))
+
+	opts = &FileWalkOptions{
+		FollowSymlinks: true,
+		FileFilter:     MakeAllowedFileFilterForExts(".txt"),
+	}
+	assert.Equal(t, []string{"a.txt"}, walk(opts))
+}
+
+func TestMakeExcludeDirsFilter(t *testing.T) {
+	f := MakeExcludeDirsFilter(".git", "node_modules")
+	assert.True(t, f("a/b.go"))
+	assert.False(t, f("a/node_modules/b.js"))
+	assert.False(t, f("/abs/.git/config"))
+	assert.True(t, f("/abs/src/main.go"))
+}
@@ -1,7 +1,10 @@
 package u
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os/exec"
 	"strings"
 )
@@ -66,3 +69,35 @@ func EnsureGitClean(dir string) {
 		Must(fmt.Errorf("git repo in '%s' is not clean", dir))
 	}
 }
+
+// GitExportRevision writes files from revision rev (a commit, branch or tag)
+// of git repository in repoDir to dstDir. Doesn't change the working tree
+// of repoDir. opts can be nil
+func GitExportRevision(repoDir string, rev string, dstDir string, opts *ExtractTarOptions) error {
+	cmd := exec.Command("git", "archive", "--format=tar", rev)
+	cmd.Dir = repoDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	err = ExtractTarFromReaderWithOptions(dstDir, r, opts)
+	if err != nil {
+		// unblock git if it's still writing
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	// tar reader might not read padding at the end
+	io.Copy(ioutil.Discard, r)
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("git archive %s failed with '%s'. Output:\n%s", rev, err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package u
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// gitignorePattern is a single line from .gitignore file
+type gitignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// if true, the pattern is matched against path relative to directory
+	// of .gitignore file. Otherwise only against the last path element
+	anchored bool
+}
+
+// GitIgnore tells if a path is ignored by .gitignore files in a directory
+// tree, following the rules described in https://git-scm.com/docs/gitignore
+// .gitignore files are read on demand, as directories are checked.
+// Patterns from .git/info/exclude are also used. .git directory is
+// always ignored.
+// It's safe to use from multiple goroutines
+type GitIgnore struct {
+	// absolute path of root directory
+	root string
+	// root as given to NewGitIgnore, if it was relative
+	relRoot string
+
+	mu sync.Mutex
+	// patterns for a given directory (relative to root, with '/' separator
+	// and "" for root directory)
+	dirPatterns map[string][]*gitignorePattern
+}
+
+// NewGitIgnore returns GitIgnore for a directory tree starting at root
+func NewGitIgnore(root string) *GitIgnore {
+	g := &GitIgnore{
+		root:        root,
+		dirPatterns: map[string][]*gitignorePattern{},
+	}
+	if !filepath.IsAbs(root) {
+		g.relRoot = filepath.Clean(root)
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		g.root = abs
+	}
+	return g
+}
+
+// trimGitignoreTrailingSpaces removes trailing spaces, unless they
+// are escaped with backslash
+func trimGitignoreTrailingSpaces(s string) string {
+	for strings.HasSuffix(s, " ") {
+		n := len(s)
+		if n >= 2 && s[n-2] == '\\' {
+			return s[:n-2] + " "
+		}
+		s = s[:n-1]
+	}
+	return s
+}
+
+// parseGitignoreLine returns nil for empty lines and comments
+func parseGitignoreLine(line string) *gitignorePattern {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimGitignoreTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	p := &gitignorePattern{}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	// a slash at the beginning or in the middle anchors the pattern
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return nil
+	}
+	p.re = re
+	return p
+}
+
+// parseGitignore parses content of .gitignore file
+func parseGitignore(d []byte) []*gitignorePattern {
+	var res []*gitignorePattern
+	for _, line := range strings.Split(string(d), "\n") {
+		if p := parseGitignoreLine(line); p != nil {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// AddPatterns adds patterns as if they were in .gitignore in dir
+// (relative to root). Added patterns take precedence over patterns
+// already added for dir
+func (g *GitIgnore) AddPatterns(dir string, lines ...string) {
+	dir = gitignoreDirKey(dir)
+	patterns := g.getPatterns(dir)
+	for _, line := range lines {
+		if p := parseGitignoreLine(line); p != nil {
+			patterns = append(patterns, p)
+		}
+	}
+	g.mu.Lock()
+	g.dirPatterns[dir] = patterns
+	g.mu.Unlock()
+}
+
+func gitignoreDirKey(dir string) string {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// getPatterns returns patterns for dir, reading .gitignore if necessary
+func (g *GitIgnore) getPatterns(dir string) []*gitignorePattern {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if patterns, ok := g.dirPatterns[dir]; ok {
+		return patterns
+	}
+	var patterns []*gitignorePattern
+	dirPath := filepath.Join(g.root, filepath.FromSlash(dir))
+	if dir == "" {
+		// lower precedence than .gitignore files
+		d, err := ioutil.ReadFile(filepath.Join(dirPath, ".git", "info", "exclude"))
+		if err == nil {
+			patterns = parseGitignore(d)
+		}
+	}
+	d, err := ioutil.ReadFile(filepath.Join(dirPath, ".gitignore"))
+	if err == nil {
+		patterns = append(patterns, parseGitignore(d)...)
+	}
+	g.dirPatterns[dir] = patterns
+	return patterns
+}
+
+// matches returns true if path (relative to root, '/' separated) is
+// ignored, not looking at parent directories
+func (g *GitIgnore) matches(path string, isDir bool) bool {
+	name := path
+	if idx := strings.LastIndexByte(path, '/'); idx >= 0 {
+		name = path[idx+1:]
+	}
+	if isDir && name == ".git" {
+		return true
+	}
+	ignored := false
+	// patterns in deeper directories take precedence so we go from the root
+	dir := ""
+	for {
+		rel := path
+		if dir != "" {
+			rel = path[len(dir)+1:]
+		}
+		for _, p := range g.getPatterns(dir) {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			s := name
+			if p.anchored {
+				s = rel
+			}
+			if p.re.MatchString(s) {
+				ignored = !p.negate
+			}
+		}
+		// descend to next directory on the path
+		idx := strings.IndexByte(rel, '/')
+		if idx < 0 {
+			break
+		}
+		if dir == "" {
+			dir = rel[:idx]
+		} else {
+			dir = dir + "/" + rel[:idx]
+		}
+	}
+	return ignored
+}
+
+// IsIgnored returns true if path is ignored. path can be absolute,
+// relative to current directory and starting with root (e.g. "repo/a.go"
+// for root "repo", as given by CalcInDir) or relative to root directory.
+// A path is also ignored if any of its parent directories is ignored
+// (as in git, a file can't be re-included if its directory is excluded)
+func (g *GitIgnore) IsIgnored(path string, isDir bool) bool {
+	if !filepath.IsAbs(path) && g.relRoot != "" && g.relRoot != "." {
+		path = filepath.Clean(path)
+		if path == g.relRoot || strings.HasPrefix(path, g.relRoot+string(filepath.Separator)) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return false
+			}
+			path = abs
+		}
+	}
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(g.root, path)
+		if err != nil {
+			return false
+		}
+		path = rel
+	}
+	return g.isIgnoredRel(path, isDir)
+}
+
+// isIgnoredRel is like IsIgnored but path is always relative to root
+func (g *GitIgnore) isIgnoredRel(path string, isDir bool) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || path == ".." || strings.HasPrefix(path, "../") {
+		return false
+	}
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if g.matches(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return g.matches(path, isDir)
+}
+
+// Filter returns FilterFunc that returns true for paths that are not
+// ignored. Paths ending with path separator are treated as directories,
+// so it can be used as both DirFilter and FileFilter in FileWalkOptions.
+// Paths are interpreted like in IsIgnored, so it also works with CalcInDir
+func (g *GitIgnore) Filter() FilterFunc {
+	return func(path string) bool {
+		return !g.IsIgnored(path, isDirFilterPath(path))
+	}
+}
+
+// relFilter is like Filter but paths are always relative to root,
+// as in FileWalkOptions filters
+func (g *GitIgnore) relFilter() FilterFunc {
+	return func(path string) bool {
+		return !g.isIgnoredRel(path, isDirFilterPath(path))
+	}
+}
+
+func isDirFilterPath(path string) bool {
+	return strings.HasSuffix(path, string(filepath.Separator)) || strings.HasSuffix(path, "/")
+}
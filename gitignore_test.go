@@ -0,0 +1,182 @@
+package u
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		// no slash: matches name at any level
+		{"*.log", "a.log", false, true},
+		{"*.log", "sub/deep/a.log", false, true},
+		{"*.log", "a.log.txt", false, false},
+		// * doesn't match '/'
+		{"doc/*.txt", "doc/a.txt", false, true},
+		{"doc/*.txt", "doc/sub/a.txt", false, false},
+		// slash in the middle or at the start anchors to .gitignore dir
+		{"doc/a.txt", "sub/doc/a.txt", false, false},
+		{"/a.txt", "a.txt", false, true},
+		{"/a.txt", "sub/a.txt", false, false},
+		// trailing slash matches only directories
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "sub/build/x.o", false, true},
+		// **
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo/bar", "a/foo/bar", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "abc", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "xa/b", false, false},
+		// ?, character classes
+		{"?.c", "a.c", false, true},
+		{"?.c", "ab.c", false, false},
+		{"[ab].c", "b.c", false, true},
+		{"[!ab].c", "b.c", false, false},
+		{"[!ab].c", "c.c", false, true},
+		{"file[0-9]", "file7", false, true},
+		// escapes, comments, trailing spaces
+		{`\#notcomment`, "#notcomment", false, true},
+		{"#comment", "#comment", false, false},
+		{`\!important`, "!important", false, true},
+		{"trailing   ", "trailing", false, true},
+		{`space\ `, "space ", false, true},
+		{`\*`, "*", false, true},
+		{`\*`, "a", false, false},
+	}
+	for _, test := range tests {
+		g := NewGitIgnore("dir_that_doesnt_exist")
+		g.AddPatterns("", test.pattern)
+		got := g.IsIgnored(test.path, test.isDir)
+		assert.Equal(t, test.ignored, got, "pattern: '%s', path: '%s'", test.pattern, test.path)
+	}
+}
+
+func TestGitIgnoreNegation(t *testing.T) {
+	g := NewGitIgnore("dir_that_doesnt_exist")
+	g.AddPatterns("", "*.log", "!keep.log")
+	assert.True(t, g.IsIgnored("a.log", false))
+	assert.False(t, g.IsIgnored("keep.log", false))
+
+	// can't re-include a file if its parent directory is excluded
+	g = NewGitIgnore("dir_that_doesnt_exist")
+	g.AddPatterns("", "build/", "!build/keep.txt")
+	assert.True(t, g.IsIgnored("build/keep.txt", false))
+
+	// but can if only the content is excluded
+	g = NewGitIgnore("dir_that_doesnt_exist")
+	g.AddPatterns("", "build/*", "!build/keep.txt")
+	assert.False(t, g.IsIgnored("build/keep.txt", false))
+	assert.True(t, g.IsIgnored("build/other.txt", false))
+
+	// last matching pattern wins
+	g = NewGitIgnore("dir_that_doesnt_exist")
+	g.AddPatterns("", "!a.txt", "a.txt")
+	assert.True(t, g.IsIgnored("a.txt", false))
+}
+
+func TestGitIgnoreNested(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-gitignore-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".gitignore":         "*.log\n/out/\n",
+		"a.go":               "",
+		"a.log":              "",
+		"out/x.go":           "",
+		"sub/.gitignore":     "!important.log\n/gen.go\n",
+		"sub/important.log":  "",
+		"sub/other.log":      "",
+		"sub/gen.go":         "",
+		"sub/deep/gen.go":    "",
+		".git/config":        "",
+		"sub/out/x.go":       "",
+		"node_modules/a.js":  "",
+		"node_modules/b.txt": "",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+
+	opts := &FileWalkOptions{
+		RespectGitIgnore: true,
+		DirFilter:        MakeExcludeDirsFilter("node_modules"),
+	}
+	ft := StartFileWalkWithOptions(context.Background(), dir, opts)
+	var got []string
+	for fte := range ft.FilesChan {
+		assert.Nil(t, fte.Err)
+		rel, _ := filepath.Rel(dir, fte.Path())
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	exp := []string{
+		".gitignore",
+		"a.go",
+		"sub/.gitignore",
+		"sub/deep/gen.go",
+		"sub/important.log",
+		"sub/out/x.go",
+	}
+	assert.Equal(t, exp, got)
+
+	g := NewGitIgnore(dir)
+	filter := g.Filter()
+	assert.False(t, filter(filepath.Join("sub", "gen.go")))
+	assert.True(t, filter(filepath.Join(dir, "sub", "important.log")))
+}
+
+func TestGitIgnoreCalcInDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-gitignore-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"repo/.gitignore": "/out/\n",
+		"repo/a.go":       "package a\n",
+		"repo/out/x.go":   "package x\n",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	// CalcInDir passes paths starting with dir, relative to current directory
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	err = os.Chdir(dir)
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+
+	g := NewGitIgnore("repo")
+	filter := g.Filter()
+	assert.False(t, filter(filepath.Join("repo", "out", "x.go")))
+	assert.True(t, filter(filepath.Join("repo", "a.go")))
+	// relative to root
+	assert.False(t, filter(filepath.Join("out", "x.go")))
+
+	stats := NewLineStats()
+	err = stats.CalcInDir("repo", filter, true)
+	assert.Nil(t, err)
+	var got []string
+	for path := range stats.FileToCount {
+		got = append(got, filepath.ToSlash(path))
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{"repo/.gitignore", "repo/a.go"}, got)
+}
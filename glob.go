@@ -0,0 +1,289 @@
+package u
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// globPattern is a compiled glob pattern
+type globPattern struct {
+	re     *regexp.Regexp
+	negate bool
+	// directory part of the pattern before first wildcard, ending with '/'
+	// or "" if the pattern starts with a wildcard
+	prefix string
+}
+
+// globToRegexp converts a glob pattern into regular expression.
+// Supports *, ?, character classes ([a-z], [!a-z]), \ escapes and **:
+// leading "**/" matches in all directories, trailing "/**" matches
+// everything inside and "/**/" matches zero or more directories
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	n := len(pattern)
+	for i := 0; i < n; i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < n && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				atEnd := i+2 == n || pattern[i+2] == '/'
+				if atStart && atEnd {
+					if i+2 == n {
+						// "/**" at the end (or "**" alone)
+						sb.WriteString(".*")
+						i++
+					} else {
+						// "**/" matches zero or more directories
+						sb.WriteString("(?:.*/)?")
+						i += 2
+					}
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+			// consecutive stars that are not ** are the same as one
+			for i+1 < n && pattern[i+1] == '*' {
+				i++
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			// find closing ']'. ']' right after '[' or '[!' is part of the class
+			j := i + 1
+			if j < n && pattern[j] == '!' {
+				j++
+			}
+			if j < n && pattern[j] == ']' {
+				j++
+			}
+			end := strings.IndexByte(pattern[j:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			end += j
+			class := pattern[i+1 : end]
+			sb.WriteByte('[')
+			if strings.HasPrefix(class, "!") {
+				sb.WriteByte('^')
+				class = class[1:]
+			}
+			sb.WriteString(strings.Replace(class, `\`, `\\`, -1))
+			sb.WriteByte(']')
+			i = end
+		case '\\':
+			if i+1 < n {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			} else {
+				sb.WriteString(`\\`)
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// expandBraces expands {a,b} alternatives in a pattern e.g. "*.{go,js}"
+// becomes "*.go" and "*.js". Braces can be nested.
+// Braces without ',' inside are not expanded
+func expandBraces(pattern string) []string {
+	start := -1
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			alts := splitBraceAlternatives(pattern[start+1 : i])
+			if len(alts) < 2 {
+				continue
+			}
+			var res []string
+			for _, alt := range alts {
+				res = append(res, expandBraces(pattern[:start]+alt+pattern[i+1:])...)
+			}
+			return res
+		}
+	}
+	return []string{pattern}
+}
+
+// splitBraceAlternatives splits s on ',' that are not inside nested braces
+func splitBraceAlternatives(s string) []string {
+	var res []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
+
+// globStaticPrefix returns directory part of pattern before the first
+// wildcard
+func globStaticPrefix(pattern string) string {
+	idx := strings.IndexAny(pattern, `*?[\`)
+	if idx < 0 {
+		idx = len(pattern)
+	}
+	slash := strings.LastIndexByte(pattern[:idx], '/')
+	return pattern[:slash+1]
+}
+
+func compileGlobPatterns(patterns []string) ([]*globPattern, error) {
+	var res []*globPattern
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		for _, p := range expandBraces(pattern) {
+			p = strings.TrimPrefix(p, "/")
+			re, err := regexp.Compile("^" + globToRegexp(p) + "$")
+			if err != nil {
+				return nil, err
+			}
+			gp := &globPattern{
+				re:     re,
+				negate: negate,
+				prefix: globStaticPrefix(p),
+			}
+			res = append(res, gp)
+		}
+	}
+	return res, nil
+}
+
+// globMatches returns true if path matches any of positive patterns
+// and none of negative patterns. If there are only negative patterns,
+// every path matches the positive part
+func globMatches(patterns []*globPattern, path string) bool {
+	path = filepath.ToSlash(path)
+	matched := true
+	for _, p := range patterns {
+		if !p.negate {
+			matched = false
+			break
+		}
+	}
+	for _, p := range patterns {
+		if !p.re.MatchString(path) {
+			continue
+		}
+		if p.negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// GlobMatch returns true if path matches glob pattern. Besides *, ? and
+// character classes ([a-z], [!a-z]) the pattern can use ** to match any
+// number of directories (e.g. "cmd/**/*.go") and {a,b} alternatives
+// (e.g. "*.{go,js}"). Patterns are matched against the whole path, so
+// "*.go" only matches files at the top level.
+func GlobMatch(pattern string, path string) (bool, error) {
+	patterns, err := compileGlobPatterns([]string{pattern})
+	if err != nil {
+		return false, err
+	}
+	return globMatches(patterns, path), nil
+}
+
+// MakeGlobFilter returns FilterFunc that returns true if a path matches
+// any of the patterns (see GlobMatch). Patterns starting with '!' are
+// negated: path matching them is rejected, e.g. "cmd/**/*.go",
+// "!**/*_test.go" matches Go files under cmd except tests
+func MakeGlobFilter(patterns ...string) (FilterFunc, error) {
+	compiled, err := compileGlobPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return func(path string) bool {
+		return globMatches(compiled, path)
+	}, nil
+}
+
+// MakeGlobFilterMust is like MakeGlobFilter but panics on invalid pattern
+func MakeGlobFilterMust(patterns ...string) FilterFunc {
+	f, err := MakeGlobFilter(patterns...)
+	Must(err)
+	return f
+}
+
+// globCanMatchInDir returns true if files in dir (relative to root, with
+// '/' at the end) can match any of positive patterns
+func globCanMatchInDir(patterns []*globPattern, dir string) bool {
+	hasPositive := false
+	for _, p := range patterns {
+		if p.negate {
+			continue
+		}
+		hasPositive = true
+		if strings.HasPrefix(dir, p.prefix) || strings.HasPrefix(p.prefix, dir) {
+			return true
+		}
+	}
+	return !hasPositive
+}
+
+// Glob returns sorted list of files in root whose paths, relative to root,
+// match patterns (see MakeGlobFilter). Returned paths include root.
+// Directories that can't contain matching files are not visited.
+func Glob(root string, patterns ...string) ([]string, error) {
+	compiled, err := compileGlobPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	opts := &FileWalkOptions{
+		DirFilter: func(path string) bool {
+			return globCanMatchInDir(compiled, filepath.ToSlash(path))
+		},
+		FileFilter: func(path string) bool {
+			return globMatches(compiled, path)
+		},
+	}
+	ft := StartFileWalkWithOptions(context.Background(), root, opts)
+	var res []string
+	for fte := range ft.FilesChan {
+		if fte.Err != nil {
+			// drain the channel so that walker goroutines exit
+			ft.Stop()
+			return nil, fte.Err
+		}
+		res = append(res, fte.Path())
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package u
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandBraces(t *testing.T) {
+	assert.Equal(t, []string{"a.go", "a.js"}, expandBraces("a.{go,js}"))
+	assert.Equal(t, []string{"a1", "a2x", "a2y"}, expandBraces("a{1,2{x,y}}"))
+	assert.Equal(t, []string{"{a}"}, expandBraces("{a}"))
+	assert.Equal(t, []string{`\{a,b}`}, expandBraces(`\{a,b}`))
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		exp     bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "cmd/a.go", false},
+		{"**/*.go", "a.go", true},
+		{"**/*.go", "cmd/x/a.go", true},
+		{"cmd/**", "cmd/x/a.go", true},
+		{"cmd/**/*.go", "cmd/a.go", true},
+		{"cmd/**/*.go", "pkg/a.go", false},
+		{"*.{go,js}", "a.js", true},
+		{"*.{go,js}", "a.ts", false},
+		{"file[0-9].txt", "file1.txt", true},
+		{"file[!0-9].txt", "file1.txt", false},
+		{"?.txt", "a.txt", true},
+	}
+	for _, test := range tests {
+		got, err := GlobMatch(test.pattern, test.path)
+		assert.Nil(t, err)
+		assert.Equal(t, test.exp, got, "pattern: '%s', path: '%s'", test.pattern, test.path)
+	}
+	_, err := GlobMatch("[z-a]", "a")
+	assert.Error(t, err)
+}
+
+func TestGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-glob-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"main.go", "cmd/a/a.go", "cmd/a/a_test.go", "cmd/b.go", "pkg/c.go"} {
+		err = WriteFileCreateDirMust(nil, filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	files, err := Glob(dir, "cmd/**/*.go", "!**/*_test.go")
+	assert.Nil(t, err)
+	exp := []string{
+		filepath.Join(dir, "cmd", "a", "a.go"),
+		filepath.Join(dir, "cmd", "b.go"),
+	}
+	assert.Equal(t, exp, files)
+
+	filter := MakeFilterAnd(MakeGlobFilterMust("**/*.go"), MakeExcludeDirsFilter("cmd"))
+	assert.True(t, filter("pkg/c.go"))
+	assert.False(t, filter("cmd/b.go"))
+}
@@ -1,19 +1,21 @@
 module github.com/kjk/u
 
-go 1.13
+go 1.16
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/dustin/go-humanize v1.0.0
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/kjk/atomicfile v0.0.0-20190916063300-2d5c7d7d05bf
+	github.com/klauspost/compress v1.15.1
 	github.com/klauspost/cpuid v1.3.1 // indirect
 	github.com/minio/minio-go/v6 v6.0.57
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
-	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.3.0
+	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
 	golang.org/x/net v0.0.0-20201024042810-be3efd7ff127 // indirect
-	golang.org/x/sys v0.0.0-20201022201747-fb209a7c41cd // indirect
+	golang.org/x/sys v0.0.0-20201022201747-fb209a7c41cd
 	gopkg.in/ini.v1 v1.62.0 // indirect
 )
@@ -0,0 +1,256 @@
+package u
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// GzipOptions describes options for GzipFileWithOptions and
+// NewParallelGzipWriter
+type GzipOptions struct {
+	// compression level. 0 means gzip.BestCompression (same as GzipFile)
+	Level int
+	// number of goroutines compressing blocks. 0 means runtime.NumCPU().
+	// 1 means compressing on a single core with standard gzip writer
+	Workers int
+	// size of block compressed by a single goroutine. 0 means 1 MB
+	BlockSize int
+}
+
+// deflate can refer back up to 32 kB so that's how much of the previous
+// block we use as a dictionary
+const gzipDictSize = 32 * 1024
+
+var errParallelGzipClosed = errors.New("ParallelGzipWriter is closed")
+
+// ParallelGzipWriter compresses data with gzip using multiple goroutines,
+// like pigz. Input is split into blocks which are compressed independently
+// (using the end of previous block as a dictionary) and concatenated into
+// a single deflate stream, so the result is a standard gzip file
+type ParallelGzipWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+
+	buf    []byte
+	dict   []byte
+	crc    uint32
+	size   uint32
+	closed bool
+
+	// compressed blocks, in order they must be written
+	blocks chan chan []byte
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func (o *GzipOptions) withDefaults() GzipOptions {
+	var res GzipOptions
+	if o != nil {
+		res = *o
+	}
+	if res.Level == 0 {
+		res.Level = gzip.BestCompression
+	}
+	if res.Workers <= 0 {
+		res.Workers = runtime.NumCPU()
+	}
+	if res.BlockSize <= 0 {
+		res.BlockSize = 1024 * 1024
+	}
+	return res
+}
+
+// NewParallelGzipWriter returns a writer that compresses data written to it
+// and writes it to w. opts can be nil. Must call Close() to flush the data
+func NewParallelGzipWriter(w io.Writer, opts *GzipOptions) (*ParallelGzipWriter, error) {
+	o := opts.withDefaults()
+	if o.Level < gzip.HuffmanOnly || o.Level > gzip.BestCompression {
+		return nil, errors.New("gzip: invalid compression level")
+	}
+	res := &ParallelGzipWriter{
+		w:         w,
+		level:     o.Level,
+		blockSize: o.BlockSize,
+		blocks:    make(chan chan []byte, o.Workers),
+	}
+	res.wg.Add(1)
+	go res.writeBlocks()
+	return res, nil
+}
+
+func (w *ParallelGzipWriter) getErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *ParallelGzipWriter) setErr(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+// writeBlocks writes gzip header and compressed blocks in order
+func (w *ParallelGzipWriter) writeBlocks() {
+	defer w.wg.Done()
+	hdr := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if w.level == gzip.BestCompression {
+		hdr[8] = 2
+	} else if w.level == gzip.BestSpeed {
+		hdr[8] = 4
+	}
+	_, err := w.w.Write(hdr)
+	if err != nil {
+		w.setErr(err)
+	}
+	for ch := range w.blocks {
+		d := <-ch
+		if w.getErr() != nil {
+			// must keep draining so that Write() doesn't block
+			continue
+		}
+		_, err = w.w.Write(d)
+		if err != nil {
+			w.setErr(err)
+		}
+	}
+}
+
+func compressGzipBlock(data []byte, dict []byte, level int, last bool) []byte {
+	var buf bytes.Buffer
+	// level is validated in NewParallelGzipWriter
+	fw, _ := flate.NewWriterDict(&buf, level, dict)
+	_, _ = fw.Write(data)
+	if last {
+		_ = fw.Close()
+	} else {
+		// sync flush ends the block on a byte boundary without marking
+		// it as final so that blocks can be concatenated
+		_ = fw.Flush()
+	}
+	return buf.Bytes()
+}
+
+func (w *ParallelGzipWriter) sendBlock(last bool) {
+	data := w.buf
+	dict := w.dict
+	ch := make(chan []byte, 1)
+	// blocks if there are too many blocks in flight
+	w.blocks <- ch
+	go func() {
+		ch <- compressGzipBlock(data, dict, w.level, last)
+	}()
+	if len(data) > gzipDictSize {
+		w.dict = data[len(data)-gzipDictSize:]
+	} else {
+		w.dict = append(append([]byte{}, w.dict...), data...)
+		if len(w.dict) > gzipDictSize {
+			w.dict = w.dict[len(w.dict)-gzipDictSize:]
+		}
+	}
+	w.buf = nil
+}
+
+// Write implements io.Writer
+func (w *ParallelGzipWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errParallelGzipClosed
+	}
+	if err := w.getErr(); err != nil {
+		return 0, err
+	}
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p)
+	w.size += uint32(len(p))
+	n := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.blockSize)
+		}
+		toCopy := w.blockSize - len(w.buf)
+		if toCopy > len(p) {
+			toCopy = len(p)
+		}
+		w.buf = append(w.buf, p[:toCopy]...)
+		p = p[toCopy:]
+		if len(w.buf) == w.blockSize {
+			w.sendBlock(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses remaining data and writes gzip trailer.
+// Doesn't close underlying writer
+func (w *ParallelGzipWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.sendBlock(true)
+	close(w.blocks)
+	w.wg.Wait()
+	if err := w.getErr(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], w.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], w.size)
+	_, err := w.w.Write(trailer[:])
+	return err
+}
+
+// GzipFileWithOptions is like GzipFile but allows to customize compression
+// level and to compress using multiple cores (see ParallelGzipWriter)
+func GzipFileWithOptions(dstPath, srcPath string, opts *GzipOptions) error {
+	o := opts.withDefaults()
+	if o.Workers == 1 {
+		return CompressFile(dstPath, srcPath, CompressionGzip, o.Level)
+	}
+	fSrc, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer fSrc.Close()
+	fDst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	err = copyParallelGzip(fDst, fSrc, &o)
+	if err != nil {
+		fDst.Close()
+		os.Remove(dstPath)
+		return err
+	}
+	err = fDst.Close()
+	if err != nil {
+		os.Remove(dstPath)
+		return err
+	}
+	return nil
+}
+
+func copyParallelGzip(dst io.Writer, src io.Reader, opts *GzipOptions) error {
+	w, err := NewParallelGzipWriter(dst, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
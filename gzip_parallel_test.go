@@ -0,0 +1,50 @@
+package u
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testParallelGzip(t *testing.T, d []byte, opts *GzipOptions) {
+	var buf bytes.Buffer
+	w, err := NewParallelGzipWriter(&buf, opts)
+	assert.Nil(t, err)
+	// write in uneven chunks to cross block boundaries
+	for i := 0; i < len(d); i += 777 {
+		end := i + 777
+		if end > len(d) {
+			end = len(d)
+		}
+		_, err = w.Write(d[i:end])
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	r, err := gzip.NewReader(&buf)
+	assert.Nil(t, err)
+	d2, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, d, d2)
+}
+
+func TestParallelGzip(t *testing.T) {
+	d, err := ioutil.ReadFile("compress.go")
+	assert.Nil(t, err)
+	big := bytes.Repeat(d, 20)
+	testParallelGzip(t, []byte{}, nil)
+	testParallelGzip(t, d, nil)
+	testParallelGzip(t, d, &GzipOptions{Workers: 3, BlockSize: 1000})
+	testParallelGzip(t, big, &GzipOptions{Workers: 4, BlockSize: 40 * 1024, Level: gzip.BestSpeed})
+
+	dstPath := "gzip_parallel_test.go.gz"
+	defer os.Remove(dstPath)
+	err = GzipFileWithOptions(dstPath, "compress.go", &GzipOptions{BlockSize: 4096})
+	assert.Nil(t, err)
+	d2, err := ReadFileMaybeCompressed(dstPath)
+	assert.Nil(t, err)
+	assert.Equal(t, d, d2)
+}
@@ -0,0 +1,200 @@
+package u
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// HashAlgo is a hash algorithm
+type HashAlgo int
+
+const (
+	HashSha1 HashAlgo = iota
+	HashSha256
+	HashSha512
+	// md5 is what S3 uses for ETag of non-multipart uploads
+	HashMd5
+	// crc32 with Castagnoli polynomial
+	HashCrc32c
+	// xxhash64, fast non-cryptographic hash
+	HashXxhash
+)
+
+var hashAlgoNames = []string{"sha1", "sha256", "sha512", "md5", "crc32c", "xxhash"}
+
+func (a HashAlgo) String() string {
+	if a >= 0 && int(a) < len(hashAlgoNames) {
+		return hashAlgoNames[a]
+	}
+	return fmt.Sprintf("HashAlgo(%d)", int(a))
+}
+
+// ParseHashAlgo returns HashAlgo for a name like "sha256"
+func ParseHashAlgo(s string) (HashAlgo, error) {
+	s = strings.ToLower(s)
+	for i, name := range hashAlgoNames {
+		if s == name {
+			return HashAlgo(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm '%s'", s)
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewHash returns a new hash.Hash for algo
+func NewHash(algo HashAlgo) hash.Hash {
+	switch algo {
+	case HashSha1:
+		return sha1.New()
+	case HashSha256:
+		return sha256.New()
+	case HashSha512:
+		return sha512.New()
+	case HashMd5:
+		return md5.New()
+	case HashCrc32c:
+		return crc32.New(crc32cTable)
+	case HashXxhash:
+		return xxhash.New()
+	}
+	panic(fmt.Sprintf("unknown hash algorithm %s", algo))
+}
+
+// HashOfReader returns hash of everything read from r
+func HashOfReader(algo HashAlgo, r io.Reader) ([]byte, error) {
+	h := NewHash(algo)
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// HashOfFile returns hash of file content
+func HashOfFile(algo HashAlgo, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return HashOfReader(algo, f)
+}
+
+// HashHexOfFile returns hex-encoded hash of file content
+func HashHexOfFile(algo HashAlgo, path string) (string, error) {
+	d, err := HashOfFile(algo, path)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(d), nil
+}
+
+// HashOfBytes returns hash of data
+func HashOfBytes(algo HashAlgo, data []byte) []byte {
+	h := NewHash(algo)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// HashHexOfBytes returns hex-encoded hash of data
+func HashHexOfBytes(algo HashAlgo, data []byte) string {
+	return hex.EncodeToString(HashOfBytes(algo, data))
+}
+
+// ChecksumEntry is a single line in a checksum file
+type ChecksumEntry struct {
+	Hash string
+	Name string
+}
+
+// ParseChecksumFile parses content in the format written by sha256sum
+// (and sha1sum, md5sum etc.): "<hex hash>  <name>" per line.
+// '*' before name (binary mode) is accepted
+func ParseChecksumFile(d []byte) ([]*ChecksumEntry, error) {
+	var res []*ChecksumEntry
+	for i, line := range strings.Split(string(d), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		idx := strings.IndexByte(line, ' ')
+		if idx <= 0 || idx+2 > len(line) {
+			return nil, fmt.Errorf("invalid checksum line %d: '%s'", i+1, line)
+		}
+		hash := line[:idx]
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("invalid checksum line %d: '%s'", i+1, line)
+		}
+		// second character is ' ' for text mode and '*' for binary mode
+		mode := line[idx+1]
+		if mode != ' ' && mode != '*' {
+			return nil, fmt.Errorf("invalid checksum line %d: '%s'", i+1, line)
+		}
+		e := &ChecksumEntry{
+			Hash: strings.ToLower(hash),
+			Name: line[idx+2:],
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// WriteChecksumFile writes hashes of files in the format of sha256sum.
+// files are relative to directory of path and are written in that order
+func WriteChecksumFile(path string, algo HashAlgo, files ...string) error {
+	dir := filepath.Dir(path)
+	var buf bytes.Buffer
+	for _, name := range files {
+		hash, err := HashHexOfFile(algo, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", hash, filepath.ToSlash(name))
+	}
+	return WriteFileAtomic(path, buf.Bytes())
+}
+
+// VerifyChecksumFile checks files listed in checksum file at path (see
+// WriteChecksumFile). Returns names of files that are missing or whose
+// hash doesn't match. Names are relative to directory of path
+func VerifyChecksumFile(path string, algo HashAlgo) ([]string, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseChecksumFile(d)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	var failed []string
+	for _, e := range entries {
+		hash, err := HashHexOfFile(algo, filepath.Join(dir, filepath.FromSlash(e.Name)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				failed = append(failed, e.Name)
+				continue
+			}
+			return nil, err
+		}
+		if hash != e.Hash {
+			failed = append(failed, e.Name)
+		}
+	}
+	return failed, nil
+}
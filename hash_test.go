@@ -0,0 +1,76 @@
+package u
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashOfBytes(t *testing.T) {
+	d := []byte("hello")
+	tests := []struct {
+		algo HashAlgo
+		exp  string
+	}{
+		{HashSha1, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{HashSha256, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{HashMd5, "5d41402abc4b2a76b9719d911017c592"},
+		{HashCrc32c, "9a71bb4c"},
+		{HashXxhash, "26c7827d889f6da3"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, HashHexOfBytes(test.algo, d), test.algo.String())
+		got, err := HashOfReader(test.algo, strings.NewReader("hello"))
+		assert.Nil(t, err)
+		assert.Equal(t, HashOfBytes(test.algo, d), got)
+	}
+	assert.Equal(t, Sha1HexOfBytes(d), HashHexOfBytes(HashSha1, d))
+	assert.Equal(t, 128, len(HashHexOfBytes(HashSha512, d)))
+
+	algo, err := ParseHashAlgo("SHA256")
+	assert.Nil(t, err)
+	assert.Equal(t, HashSha256, algo)
+	_, err = ParseHashAlgo("sha3")
+	assert.NotNil(t, err)
+}
+
+func TestChecksumFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-checksum-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := []string{"a.txt", "sub/b.txt"}
+	for _, name := range files {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	path := filepath.Join(dir, "SHA256SUMS")
+	err = WriteChecksumFile(path, HashSha256, files...)
+	assert.Nil(t, err)
+	d, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	exp := HashHexOfBytes(HashSha256, []byte("a.txt")) + "  a.txt\n" + HashHexOfBytes(HashSha256, []byte("sub/b.txt")) + "  sub/b.txt\n"
+	assert.Equal(t, exp, string(d))
+
+	failed, err := VerifyChecksumFile(path, HashSha256)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(failed))
+
+	err = WriteFileCreateDirMust([]byte("modified"), filepath.Join(dir, "a.txt"))
+	assert.Nil(t, err)
+	err = os.Remove(filepath.Join(dir, "sub", "b.txt"))
+	assert.Nil(t, err)
+	failed, err = VerifyChecksumFile(path, HashSha256)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.txt", "sub/b.txt"}, failed)
+
+	entries, err := ParseChecksumFile([]byte("abcd *bin.exe\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bin.exe", entries[0].Name)
+	_, err = ParseChecksumFile([]byte("not a checksum line\n"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,174 @@
+package u
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// ManifestFile describes a single file in a Manifest
+type ManifestFile struct {
+	// relative to manifest directory, with '/' as separator
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// hex-encoded sha1 of the content
+	Sha1 string `json:"sha1"`
+}
+
+// Manifest is a snapshot of files in a directory
+type Manifest struct {
+	// sorted by Path
+	Files []*ManifestFile `json:"files"`
+}
+
+// ManifestRename describes a file that was moved
+type ManifestRename struct {
+	From *ManifestFile
+	To   *ManifestFile
+}
+
+// ManifestDiff describes differences between two manifests
+type ManifestDiff struct {
+	Added    []*ManifestFile
+	Removed  []*ManifestFile
+	Modified []*ManifestFile
+	// files with the same content and a different path
+	Renamed []*ManifestRename
+}
+
+// IsEmpty returns true if there are no differences
+func (d *ManifestDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && len(d.Renamed) == 0
+}
+
+// NewManifestForDir creates a manifest of all files in dir.
+// If filter is not nil, only files for which filter returns true
+// (called with path relative to dir) are included
+func NewManifestForDir(dir string, filter FilterFunc) (*Manifest, error) {
+	opts := &FileWalkOptions{
+		FileFilter: filter,
+	}
+	ft := StartFileWalkWithOptions(context.Background(), dir, opts)
+	var paths []string
+	pathToFile := map[string]*ManifestFile{}
+	for fte := range ft.FilesChan {
+		if fte.Err != nil {
+			ft.Stop()
+			return nil, fte.Err
+		}
+		path := fte.Path()
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			ft.Stop()
+			return nil, err
+		}
+		fi := fte.FileInfo
+		f := &ManifestFile{
+			Path:    filepath.ToSlash(rel),
+			Size:    fi.Size(),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+		}
+		paths = append(paths, path)
+		pathToFile[path] = f
+	}
+	hashes, err := hashFilesParallel(paths, runtime.NumCPU(), Sha1OfFile)
+	if err != nil {
+		return nil, err
+	}
+	res := &Manifest{}
+	for path, f := range pathToFile {
+		f.Sha1 = hex.EncodeToString([]byte(hashes[path]))
+		res.Files = append(res.Files, f)
+	}
+	sort.Slice(res.Files, func(i, j int) bool {
+		return res.Files[i].Path < res.Files[j].Path
+	})
+	return res, nil
+}
+
+// ManifestFromJSON decodes manifest serialized with json.Marshal
+func ManifestFromJSON(d []byte) (*Manifest, error) {
+	var m Manifest
+	err := json.Unmarshal(d, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SaveManifest saves manifest as JSON file, atomically
+func SaveManifest(path string, m *Manifest) error {
+	d, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, d)
+}
+
+// LoadManifest loads manifest saved with SaveManifest
+func LoadManifest(path string) (*Manifest, error) {
+	d, err := ReadFileMaybeCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+	return ManifestFromJSON(d)
+}
+
+// DiffManifests returns changes needed to go from before to after.
+// A file is modified if its content or mode changed. Files removed from
+// one path and added under another with the same content are renamed.
+func DiffManifests(before, after *Manifest) *ManifestDiff {
+	res := &ManifestDiff{}
+	beforeFiles := map[string]*ManifestFile{}
+	for _, f := range before.Files {
+		beforeFiles[f.Path] = f
+	}
+	afterFiles := map[string]*ManifestFile{}
+	for _, f := range after.Files {
+		afterFiles[f.Path] = f
+	}
+	var added []*ManifestFile
+	for _, f := range after.Files {
+		prev := beforeFiles[f.Path]
+		if prev == nil {
+			added = append(added, f)
+			continue
+		}
+		if prev.Sha1 != f.Sha1 || prev.Size != f.Size || prev.Mode != f.Mode {
+			res.Modified = append(res.Modified, f)
+		}
+	}
+	// removed files by hash, to find renames
+	removedByHash := map[string][]*ManifestFile{}
+	for _, f := range before.Files {
+		if afterFiles[f.Path] == nil {
+			removedByHash[f.Sha1] = append(removedByHash[f.Sha1], f)
+		}
+	}
+	renamedFrom := map[*ManifestFile]bool{}
+	for _, f := range added {
+		candidates := removedByHash[f.Sha1]
+		if len(candidates) == 0 {
+			res.Added = append(res.Added, f)
+			continue
+		}
+		from := candidates[0]
+		removedByHash[f.Sha1] = candidates[1:]
+		renamedFrom[from] = true
+		res.Renamed = append(res.Renamed, &ManifestRename{From: from, To: f})
+	}
+	for _, f := range before.Files {
+		if afterFiles[f.Path] == nil && !renamedFrom[f] {
+			res.Removed = append(res.Removed, f)
+		}
+	}
+	return res
+}
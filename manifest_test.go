@@ -0,0 +1,68 @@
+package u
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-manifest-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "b",
+		"sub/c.txt": "c",
+		"old.txt":   "will be renamed",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	before, err := NewManifestForDir(dir, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(before.Files))
+	assert.Equal(t, "a.txt", before.Files[0].Path)
+	assert.Equal(t, "sub/b.txt", before.Files[2].Path)
+	assert.Equal(t, Sha1HexOfBytes([]byte("a")), before.Files[0].Sha1)
+
+	// round-trip through a file
+	path := filepath.Join(dir, "..", filepath.Base(dir)+"-manifest.json")
+	defer os.Remove(path)
+	err = SaveManifest(path, before)
+	assert.Nil(t, err)
+	before, err = LoadManifest(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(before.Files))
+
+	after, err := NewManifestForDir(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, DiffManifests(before, after).IsEmpty())
+
+	err = WriteFileCreateDirMust([]byte("b modified"), filepath.Join(dir, "sub", "b.txt"))
+	assert.Nil(t, err)
+	err = os.Remove(filepath.Join(dir, "sub", "c.txt"))
+	assert.Nil(t, err)
+	err = WriteFileCreateDirMust([]byte("new"), filepath.Join(dir, "new.txt"))
+	assert.Nil(t, err)
+	err = os.Rename(filepath.Join(dir, "old.txt"), filepath.Join(dir, "sub", "renamed.txt"))
+	assert.Nil(t, err)
+
+	after, err = NewManifestForDir(dir, nil)
+	assert.Nil(t, err)
+	diff := DiffManifests(before, after)
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, "new.txt", diff.Added[0].Path)
+	assert.Equal(t, 1, len(diff.Removed))
+	assert.Equal(t, "sub/c.txt", diff.Removed[0].Path)
+	assert.Equal(t, 1, len(diff.Modified))
+	assert.Equal(t, "sub/b.txt", diff.Modified[0].Path)
+	assert.Equal(t, 1, len(diff.Renamed))
+	assert.Equal(t, "old.txt", diff.Renamed[0].From.Path)
+	assert.Equal(t, "sub/renamed.txt", diff.Renamed[0].To.Path)
+}
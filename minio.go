@@ -2,6 +2,7 @@ package u
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -179,6 +180,64 @@ func (c *MinioClient) DownloadFileAtomically(dstPath string, remotePath string)
 	return f.Close()
 }
 
+// OpenZip opens zip archive stored under remotePath without downloading
+// all of it. Must call Close() on the result when done
+func (c *MinioClient) OpenZip(remotePath string) (*ZipReader, error) {
+	client, err := c.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	opts := minio.GetObjectOptions{}
+	obj, err := client.GetObject(c.Bucket, remotePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	st, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	r, err := NewZipReaderAt(obj, st.Size)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// UploadManifest uploads manifest as a private JSON file
+func (c *MinioClient) UploadManifest(remotePath string, m *Manifest) error {
+	d, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(d)
+	return c.UploadReaderPrivate(remotePath, r, int64(len(d)), "application/json")
+}
+
+// DownloadManifest downloads manifest uploaded with UploadManifest
+func (c *MinioClient) DownloadManifest(remotePath string) (*Manifest, error) {
+	d, err := c.DownloadFileAsData(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return ManifestFromJSON(d)
+}
+
+// DiffDirWithManifest returns changes from manifest stored under
+// remotePath to current content of dir
+func (c *MinioClient) DiffDirWithManifest(dir string, remotePath string, filter FilterFunc) (*ManifestDiff, error) {
+	before, err := c.DownloadManifest(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	after, err := NewManifestForDir(dir, filter)
+	if err != nil {
+		return nil, err
+	}
+	return DiffManifests(before, after), nil
+}
+
 func (c *MinioClient) UploadFilePublic(remotePath string, filePath string) error {
 	return c.UploadFile(remotePath, filePath, true)
 }
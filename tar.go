@@ -0,0 +1,374 @@
+package u
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// safeJoin returns dstDir joined with name from an archive.
+// Returns an error if resulting path would be outside of dstDir
+// (e.g. name is absolute or has ".." in it)
+func safeJoin(dstDir string, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry '%s' has absolute path", name)
+	}
+	path := filepath.Join(dstDir, name)
+	rel, err := filepath.Rel(dstDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry '%s' is outside of destination directory", name)
+	}
+	return path, nil
+}
+
+// ensureNoSymlinkInPath returns an error if any directory between dstDir
+// and path is a symlink. Writing through such symlink could write
+// outside of dstDir. dstDir itself and its parents can be symlinks
+func ensureNoSymlinkInPath(dstDir string, path string) error {
+	rel, err := filepath.Rel(dstDir, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	// path is dstDir itself (e.g. "./" entry)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	dir := dstDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("'%s' is a symlink", dir)
+		}
+	}
+	return nil
+}
+
+// checkSymlinkTarget returns an error if archive entry name, extracted
+// to path, is a symlink to target outside of dstDir.
+// ".." after other path elements (e.g. "x/..") is rejected because x might
+// be a symlink created by an earlier entry, in which case "x/.." is not
+// what it looks like. Otherwise the target is resolved lexically and,
+// if it already exists, following symlinks on disk
+func checkSymlinkTarget(dstDir string, name string, path string, target string) error {
+	t := filepath.FromSlash(target)
+	if t == "" || filepath.IsAbs(t) || strings.HasPrefix(t, string(filepath.Separator)) || filepath.VolumeName(t) != "" {
+		return fmt.Errorf("archive entry '%s' is a symlink to absolute path '%s'", name, target)
+	}
+	seenName := false
+	for _, part := range strings.Split(t, string(filepath.Separator)) {
+		switch part {
+		case "", ".":
+		case "..":
+			if seenName {
+				return fmt.Errorf("archive entry '%s' is a symlink to '%s' which has '..' after a path element", name, target)
+			}
+		default:
+			seenName = true
+		}
+	}
+	errOutside := fmt.Errorf("archive entry '%s' is a symlink to '%s' outside of destination directory", name, target)
+	// target is relative to the directory of the symlink
+	linked := filepath.Join(filepath.Dir(path), t)
+	rel, err := filepath.Rel(dstDir, linked)
+	if err != nil {
+		return errOutside
+	}
+	if _, err = safeJoin(dstDir, rel); err != nil {
+		return errOutside
+	}
+	resolved, err := filepath.EvalSymlinks(linked)
+	if err != nil {
+		// doesn't exist (yet)
+		return nil
+	}
+	realDstDir, err := filepath.EvalSymlinks(dstDir)
+	if err != nil {
+		return err
+	}
+	rel, err = filepath.Rel(realDstDir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errOutside
+	}
+	return nil
+}
+
+func tarAddPath(tw *tar.Writer, name string, path string, fi os.FileInfo) error {
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(path)
+		if err != nil {
+			return err
+		}
+	} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a dir, regular file or symlink", path)
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func tarAddRecur(tw *tar.Writer, baseDir string, toTar string) error {
+	root := filepath.Join(baseDir, toTar)
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		return tarAddPath(tw, name, path, fi)
+	})
+}
+
+// CreateTarFile creates a tar archive. Compression is based on extension
+// of dst: .tar.gz (or .tgz), .tar.zst and .tar.xz are compressed,
+// anything else is uncompressed tar.
+// toTar is a list of files and directories in baseDir
+// Directories are added recursively, symlinks are stored as symlinks.
+func CreateTarFile(dst string, baseDir string, toTar ...string) error {
+	if len(toTar) == 0 {
+		return fmt.Errorf("must provide toTar args")
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = writeTar(f, dst, baseDir, toTar)
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func writeTar(w io.Writer, dst string, baseDir string, toTar []string) error {
+	var cw io.WriteCloser
+	format := CompressionFormatFromExt(dst)
+	if format != CompressionNone {
+		var err error
+		cw, err = newCompressingWriter(w, format, CompressionLevelDefault)
+		if err != nil {
+			return err
+		}
+		w = cw
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range toTar {
+		err := tarAddRecur(tw, baseDir, name)
+		if err != nil {
+			return err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	if cw != nil {
+		return cw.Close()
+	}
+	return nil
+}
+
+// CreateTarWithDirContent creates a tar archive with the content of a directory.
+// Like in CreateZipWithDirContent, the names of files inside the archive
+// are relative to dirToTar
+func CreateTarWithDirContent(tarFilePath, dirToTar string) error {
+	isDir, err := PathIsDir(dirToTar)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		return fmt.Errorf("'%s' is not a directory", dirToTar)
+	}
+	return CreateTarFile(tarFilePath, dirToTar, ".")
+}
+
+// ExtractTar extracts tar archive (compressed in any format supported
+// by OpenFileMaybeCompressed) to dstDir.
+// Preserves file modes, symlinks, hard links and modification times.
+// Entries that would be written outside of dstDir and symlinks pointing
+// outside of dstDir are rejected.
+func ExtractTar(dstDir string, tarPath string) error {
+	r, err := OpenFileMaybeCompressed(tarPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return ExtractTarFromReader(dstDir, r)
+}
+
+type dirTimes struct {
+	path  string
+	mtime time.Time
+	// if 0, permissions are not changed
+	mode os.FileMode
+}
+
+// ExtractTarOptions describes options for ExtractTarFromReaderWithOptions
+type ExtractTarOptions struct {
+	// if true, symlink entries are skipped instead of being created
+	// (or rejected if they point outside of destination directory)
+	SkipSymlinks bool
+}
+
+// ExtractTarFromReader is like ExtractTar but reads uncompressed tar data from r
+func ExtractTarFromReader(dstDir string, r io.Reader) error {
+	return ExtractTarFromReaderWithOptions(dstDir, r, nil)
+}
+
+// ExtractTarFromReaderWithOptions is like ExtractTarFromReader but can be
+// customized with opts (which can be nil)
+func ExtractTarFromReaderWithOptions(dstDir string, r io.Reader, opts *ExtractTarOptions) error {
+	if opts == nil {
+		opts = &ExtractTarOptions{}
+	}
+	err := CreateDir(dstDir)
+	if err != nil {
+		return err
+	}
+	// setting mtime and permissions of directories must happen after
+	// we're done writing files in them (directory might be read-only)
+	var dirs []dirTimes
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path, err := safeJoin(dstDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err = ensureNoSymlinkInPath(dstDir, path); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+			dirs = append(dirs, dirTimes{path, hdr.ModTime, mode.Perm()})
+		case tar.TypeReg:
+			err = extractTarFile(path, tr, mode.Perm(), hdr.ModTime)
+		case tar.TypeSymlink:
+			if opts.SkipSymlinks {
+				break
+			}
+			err = CreateDirForFile(path)
+			if err == nil {
+				err = checkSymlinkTarget(dstDir, hdr.Name, path, hdr.Linkname)
+			}
+			if err == nil {
+				os.Remove(path)
+				err = os.Symlink(hdr.Linkname, path)
+			}
+		case tar.TypeLink:
+			var target string
+			target, err = safeJoin(dstDir, hdr.Linkname)
+			if err == nil {
+				err = CreateDirForFile(path)
+			}
+			if err == nil {
+				os.Remove(path)
+				err = os.Link(target, path)
+			}
+		default:
+			// skip devices, fifos etc.
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return setDirTimes(dirs)
+}
+
+// setDirTimes sets modification times and permissions of directories,
+// deepest first
+func setDirTimes(dirs []dirTimes) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if d.mode != 0 {
+			err := os.Chmod(d.path, d.mode)
+			if err != nil {
+				return err
+			}
+		}
+		err := os.Chtimes(d.path, d.mtime, d.mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarFile(path string, r io.Reader, perm os.FileMode, mtime time.Time) error {
+	err := CreateDirForFile(path)
+	if err != nil {
+		return err
+	}
+	// remove first so that we don't write through existing symlink
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	// OpenFile applies umask to perm
+	err = os.Chmod(path, perm)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
@@ -0,0 +1,160 @@
+package u
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-tar-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/run.sh"} {
+		path := filepath.Join(srcDir, name)
+		err = WriteFileCreateDirMust([]byte(name), path)
+		assert.Nil(t, err)
+		err = os.Chtimes(path, mtime, mtime)
+		assert.Nil(t, err)
+	}
+	err = os.Chmod(filepath.Join(srcDir, "sub", "run.sh"), 0755)
+	assert.Nil(t, err)
+	err = os.Symlink("a.txt", filepath.Join(srcDir, "link.txt"))
+	assert.Nil(t, err)
+
+	for _, name := range []string{"out.tar", "out.tar.gz", "out.tar.zst"} {
+		tarPath := filepath.Join(dir, name)
+		err = CreateTarWithDirContent(tarPath, srcDir)
+		assert.Nil(t, err)
+
+		dstDir := filepath.Join(dir, "dst-"+name)
+		err = ExtractTar(dstDir, tarPath)
+		assert.Nil(t, err)
+
+		d, err := ioutil.ReadFile(filepath.Join(dstDir, "sub", "b.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, "sub/b.txt", string(d))
+		fi, err := os.Stat(filepath.Join(dstDir, "sub", "run.sh"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+		assert.True(t, fi.ModTime().Equal(mtime))
+		link, err := os.Readlink(filepath.Join(dstDir, "link.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, "a.txt", link)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	_, err := safeJoin("dst", "../evil.txt")
+	assert.Error(t, err)
+	_, err = safeJoin("dst", "a/../../evil.txt")
+	assert.Error(t, err)
+	_, err = safeJoin("dst", "/etc/passwd")
+	assert.Error(t, err)
+	path, err := safeJoin("dst", "a/../b.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join("dst", "b.txt"), path)
+}
+
+func TestExtractTarSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-tar-symlink-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := [][][2]string{
+		{{"a", "/etc/passwd"}},
+		{{"a", "../.."}},
+		{{"sub/a", "../../x"}},
+		{{"x", "."}, {"y", "x/.."}},
+	}
+	for i, links := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, link := range links {
+			hdr := &tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     link[0],
+				Linkname: link[1],
+				Mode:     0777,
+			}
+			assert.Nil(t, tw.WriteHeader(hdr))
+		}
+		assert.Nil(t, tw.Close())
+		dst := filepath.Join(dir, "dst-"+strconv.Itoa(i))
+		err = ExtractTarFromReader(dst, bytes.NewReader(buf.Bytes()))
+		assert.Error(t, err, "%v", links)
+		_, err = os.Lstat(filepath.Join(dst, links[len(links)-1][0]))
+		assert.True(t, os.IsNotExist(err))
+
+		opts := &ExtractTarOptions{SkipSymlinks: true}
+		dst = filepath.Join(dir, "dst-skip-"+strconv.Itoa(i))
+		err = ExtractTarFromReaderWithOptions(dst, bytes.NewReader(buf.Bytes()), opts)
+		assert.Nil(t, err)
+		_, err = os.Lstat(filepath.Join(dst, links[len(links)-1][0]))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestExtractTarSymlinkedParent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-tar-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// tar -C dir . creates "./" entry
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}))
+	d := []byte("hello")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./a.txt", Mode: 0644, Size: int64(len(d))}))
+	_, err = tw.Write(d)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+
+	err = os.Mkdir(filepath.Join(dir, "real"), 0755)
+	assert.Nil(t, err)
+	err = os.Symlink("real", filepath.Join(dir, "link"))
+	assert.Nil(t, err)
+	dst := filepath.Join(dir, "link", "out")
+	err = ExtractTarFromReader(dst, &buf)
+	assert.Nil(t, err)
+	d2, err := ioutil.ReadFile(filepath.Join(dir, "real", "out", "a.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, d, d2)
+}
+
+func TestExtractTarReadOnlyDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-tar-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	err = WriteFileCreateDirMust([]byte("f"), filepath.Join(srcDir, "ro", "f.txt"))
+	assert.Nil(t, err)
+	err = os.Chmod(filepath.Join(srcDir, "ro"), 0555)
+	assert.Nil(t, err)
+	defer os.Chmod(filepath.Join(srcDir, "ro"), 0755)
+	tarPath := filepath.Join(dir, "ro.tar")
+	err = CreateTarWithDirContent(tarPath, srcDir)
+	assert.Nil(t, err)
+
+	dstDir := filepath.Join(dir, "dst")
+	err = ExtractTar(dstDir, tarPath)
+	assert.Nil(t, err)
+	defer os.Chmod(filepath.Join(dstDir, "ro"), 0755)
+	d, err := ioutil.ReadFile(filepath.Join(dstDir, "ro", "f.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "f", string(d))
+	fi, err := os.Stat(filepath.Join(dstDir, "ro"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0555), fi.Mode().Perm())
+}
@@ -1,11 +1,15 @@
 package u
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // LineCount describes line count for a file
@@ -13,10 +17,23 @@ type LineCount struct {
 	Name      string
 	Ext       string
 	LineCount int
+	// LineCount broken down into lines of code, comments and blank lines.
+	// Empty line after the final newline is not counted here, so their
+	// sum can be one less than LineCount
+	Code    int
+	Comment int
+	Blank   int
+	// file looks like binary (see IsBinaryData)
+	Binary bool
+	// file looks generated (see IsGeneratedData)
+	Generated bool
 }
 
-// LineStats gathers line count info for files
+// LineStats gathers line count info for files.
+// Add is safe to call from multiple goroutines. FileToCount shouldn't
+// be accessed directly while files are being added
 type LineStats struct {
+	mu          sync.Mutex
 	FileToCount map[string]*LineCount
 }
 
@@ -27,6 +44,16 @@ func NewLineStats() *LineStats {
 	}
 }
 
+// Add records line count for a file at path
+func (s *LineStats) Add(path string, lc *LineCount) {
+	s.mu.Lock()
+	if s.FileToCount == nil {
+		s.FileToCount = map[string]*LineCount{}
+	}
+	s.FileToCount[path] = lc
+	s.mu.Unlock()
+}
+
 func statsPerExt(fileToCount map[string]*LineCount) []*LineCount {
 	extToCount := map[string]*LineCount{}
 	for _, wc := range fileToCount {
@@ -39,6 +66,9 @@ func statsPerExt(fileToCount map[string]*LineCount) []*LineCount {
 			extToCount[ext] = extWc
 		}
 		extWc.LineCount += wc.LineCount
+		extWc.Code += wc.Code
+		extWc.Comment += wc.Comment
+		extWc.Blank += wc.Blank
 	}
 	var res []*LineCount
 	for _, wc := range extToCount {
@@ -85,7 +115,12 @@ func MakeExcludeDirsFilter(dirs ...string) FilterFunc {
 					return false
 				}
 			}
-			path = filepath.Dir(path)
+			parent := filepath.Dir(path)
+			if parent == path {
+				// reached root of absolute path
+				break
+			}
+			path = parent
 		}
 		return true
 	}
@@ -115,35 +150,43 @@ func MakeFilterAnd(filters ...FilterFunc) FilterFunc {
 
 // FileLineCount returns number of lines in a file
 func FileLineCount(path string) (int, error) {
-	d, err := ioutil.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return 0, err
 	}
-	if len(d) == 0 {
-		return 0, nil
-	}
-	d = NormalizeNewlines(d)
-	nLines := 1
-	n := len(d)
-	for i := 0; i < n; i++ {
-		if d[i] == 10 {
-			nLines++
-		}
+	defer f.Close()
+	lc, err := CountLinesReader(f, nil)
+	if err != nil {
+		return 0, err
 	}
-	return nLines, nil
+	return lc.LineCount, nil
 }
 
+// CalcInDir counts lines in files in dir (and its sub-directories if recur
+// is true). allowedFileFilter is called with full path.
+// Errors reading directories and files don't stop counting. They are
+// returned as MultiError at the end
 func (s *LineStats) CalcInDir(dir string, allowedFileFilter func(name string) bool, recur bool) error {
+	var errs MultiError
+	s.calcInDir(dir, allowedFileFilter, recur, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *LineStats) calcInDir(dir string, allowedFileFilter func(name string) bool, recur bool, errs *MultiError) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return err
+		*errs = append(*errs, err)
+		return
 	}
 	for _, fi := range files {
 		name := fi.Name()
 		path := filepath.Join(dir, name)
 		if fi.IsDir() {
 			if recur {
-				s.CalcInDir(path, allowedFileFilter, recur)
+				s.calcInDir(path, allowedFileFilter, recur, errs)
 			}
 			continue
 		}
@@ -153,15 +196,81 @@ func (s *LineStats) CalcInDir(dir string, allowedFileFilter func(name string) bo
 		if !allowedFileFilter(path) {
 			continue
 		}
-		lineCount, err := FileLineCount(path)
+		lc, err := FileLineCountDetailed(path)
 		if err != nil {
-			return err
-		}
-		s.FileToCount[path] = &LineCount{
-			Name:      fi.Name(),
-			Ext:       strings.ToLower(filepath.Ext(name)),
-			LineCount: lineCount,
+			*errs = append(*errs, err)
+			continue
 		}
+		s.Add(path, lc)
+	}
+}
+
+// MultiError is a list of errors returned as a single error
+type MultiError []error
+
+func (e MultiError) Error() string {
+	var a []string
+	for _, err := range e {
+		a = append(a, err.Error())
+	}
+	return strings.Join(a, "\n")
+}
+
+// CalcInDirParallel is like CalcInDir with recur set to true but it reads
+// directories and counts lines in files using multiple goroutines.
+// allowedFileFilter is called with full path and can be nil.
+// nWorkers <= 0 means runtime.NumCPU().
+// Errors reading directories and files don't stop counting. They are
+// returned as MultiError at the end.
+// Cancelling ctx stops counting and returns ctx.Err()
+func (s *LineStats) CalcInDirParallel(ctx context.Context, dir string, allowedFileFilter FilterFunc, nWorkers int) error {
+	if nWorkers <= 0 {
+		nWorkers = runtime.NumCPU()
+	}
+	ft := StartFileWalkWithOptions(ctx, dir, &FileWalkOptions{Workers: nWorkers})
+	var mu sync.Mutex
+	var errs MultiError
+	addErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fte := range ft.FilesChan {
+				if fte.Err != nil {
+					addErr(fte.Err)
+					continue
+				}
+				if ctx.Err() != nil {
+					// keep draining so that the walker can finish
+					continue
+				}
+				path := fte.Path()
+				if allowedFileFilter != nil && !allowedFileFilter(path) {
+					continue
+				}
+				lc, err := FileLineCountDetailed(path)
+				if err != nil {
+					addErr(err)
+					continue
+				}
+				s.Add(path, lc)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Error() < errs[j].Error()
+		})
+		return errs
 	}
 	return nil
 }
@@ -181,7 +290,7 @@ func PrintLineStats(stats *LineStats) {
 	fmt.Printf("\nPer extension:\n")
 	wcPerExt := statsPerExt(stats.FileToCount)
 	for _, wc := range wcPerExt {
-		fmt.Printf("%d %s\n", wc.LineCount, wc.Ext)
+		fmt.Printf("%d %s (code: %d, comment: %d, blank: %d)\n", wc.LineCount, wc.Ext, wc.Code, wc.Comment, wc.Blank)
 	}
 	fmt.Printf("\ntotal: %d\n", total)
 }
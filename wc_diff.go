@@ -0,0 +1,370 @@
+package u
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// statuses of files in LineStatsDiff
+const (
+	LineStatsAdded    = "added"
+	LineStatsRemoved  = "removed"
+	LineStatsModified = "modified"
+	LineStatsRenamed  = "renamed"
+)
+
+// LineStatsDiffRow is a change in line count of a file or a summary
+// of changes for multiple files. Lines, Code, Comment and Blank are
+// differences (after - before)
+type LineStatsDiffRow struct {
+	// file path (relative to compared directory), extension or "total"
+	Name string `json:"name"`
+	// for renamed files, the path before
+	OldName string `json:"old_name,omitempty"`
+	// one of LineStatsAdded, LineStatsRemoved etc. Empty for summaries
+	Status      string `json:"status,omitempty"`
+	LinesBefore int    `json:"lines_before"`
+	LinesAfter  int    `json:"lines_after"`
+	Lines       int    `json:"lines"`
+	Code        int    `json:"code"`
+	Comment     int    `json:"comment"`
+	Blank       int    `json:"blank"`
+	ext         string
+}
+
+func (r *LineStatsDiffRow) add(before, after *LineCount) {
+	if before != nil {
+		r.LinesBefore += before.LineCount
+		r.Lines -= before.LineCount
+		r.Code -= before.Code
+		r.Comment -= before.Comment
+		r.Blank -= before.Blank
+	}
+	if after != nil {
+		r.LinesAfter += after.LineCount
+		r.Lines += after.LineCount
+		r.Code += after.Code
+		r.Comment += after.Comment
+		r.Blank += after.Blank
+	}
+}
+
+// LineStatsDiff describes changes in line counts between two directories.
+// Files whose line counts didn't change are not in Files but are counted
+// in LinesBefore and LinesAfter of PerExt and Total
+type LineStatsDiff struct {
+	Files  []*LineStatsDiffRow `json:"files"`
+	PerExt []*LineStatsDiffRow `json:"per_ext"`
+	Total  *LineStatsDiffRow   `json:"total"`
+}
+
+// relLineStats returns stats keyed by path relative to dir, with '/' separator
+func relLineStats(stats *LineStats, dir string) map[string]*LineCount {
+	res := map[string]*LineCount{}
+	for path, lc := range stats.FileToCount {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			rel = path
+		}
+		res[filepath.ToSlash(rel)] = lc
+	}
+	return res
+}
+
+// DiffLineStats returns changes in line counts from stats for files in
+// beforeDir to stats for files in afterDir.
+// Files are matched by path relative to their directory. Files that were
+// removed and added with the same content (sha1) are treated as renamed
+func DiffLineStats(before *LineStats, beforeDir string, after *LineStats, afterDir string) (*LineStatsDiff, error) {
+	beforeFiles := relLineStats(before, beforeDir)
+	afterFiles := relLineStats(after, afterDir)
+
+	// hash only files that might be renamed
+	var removed, added []string
+	for name := range beforeFiles {
+		if afterFiles[name] == nil {
+			removed = append(removed, filepath.Join(beforeDir, filepath.FromSlash(name)))
+		}
+	}
+	for name := range afterFiles {
+		if beforeFiles[name] == nil {
+			added = append(added, filepath.Join(afterDir, filepath.FromSlash(name)))
+		}
+	}
+	var hashes map[string]string
+	if len(removed) > 0 && len(added) > 0 {
+		var err error
+		hashes, err = hashFilesParallel(append(removed, added...), runtime.NumCPU(), Sha1OfFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hashToRemoved := map[string][]string{}
+	sort.Strings(removed)
+	for _, path := range removed {
+		if h, ok := hashes[path]; ok {
+			hashToRemoved[h] = append(hashToRemoved[h], path)
+		}
+	}
+
+	res := &LineStatsDiff{
+		Total: &LineStatsDiffRow{Name: "total"},
+	}
+	extToRow := map[string]*LineStatsDiffRow{}
+	getExtRow := func(ext string) *LineStatsDiffRow {
+		row := extToRow[ext]
+		if row == nil {
+			row = &LineStatsDiffRow{Name: ext, ext: ext}
+			extToRow[ext] = row
+			res.PerExt = append(res.PerExt, row)
+		}
+		return row
+	}
+	addRow := func(row *LineStatsDiffRow, before, after *LineCount) {
+		row.add(before, after)
+		res.Total.add(before, after)
+		// extension can change if a file was renamed
+		if before != nil {
+			row.ext = before.Ext
+			getExtRow(before.Ext).add(before, nil)
+		}
+		if after != nil {
+			row.ext = after.Ext
+			getExtRow(after.Ext).add(nil, after)
+		}
+		res.Files = append(res.Files, row)
+	}
+
+	renamed := map[string]bool{}
+	sort.Strings(added)
+	for _, path := range added {
+		rel, _ := filepath.Rel(afterDir, path)
+		name := filepath.ToSlash(rel)
+		row := &LineStatsDiffRow{
+			Name:   name,
+			Status: LineStatsAdded,
+		}
+		var beforeLc *LineCount
+		h := hashes[path]
+		if paths := hashToRemoved[h]; len(paths) > 0 {
+			hashToRemoved[h] = paths[1:]
+			rel, _ = filepath.Rel(beforeDir, paths[0])
+			row.OldName = filepath.ToSlash(rel)
+			row.Status = LineStatsRenamed
+			renamed[row.OldName] = true
+			beforeLc = beforeFiles[row.OldName]
+		}
+		addRow(row, beforeLc, afterFiles[name])
+	}
+	for name, beforeLc := range beforeFiles {
+		afterLc := afterFiles[name]
+		if afterLc == nil {
+			if !renamed[name] {
+				row := &LineStatsDiffRow{
+					Name:   name,
+					Status: LineStatsRemoved,
+				}
+				addRow(row, beforeLc, nil)
+			}
+			continue
+		}
+		if beforeLc.LineCount == afterLc.LineCount && beforeLc.Code == afterLc.Code && beforeLc.Comment == afterLc.Comment && beforeLc.Blank == afterLc.Blank {
+			// only counts towards LinesBefore and LinesAfter of summaries
+			res.Total.add(beforeLc, afterLc)
+			getExtRow(beforeLc.Ext).add(beforeLc, afterLc)
+			continue
+		}
+		row := &LineStatsDiffRow{
+			Name:   name,
+			Status: LineStatsModified,
+		}
+		addRow(row, beforeLc, afterLc)
+	}
+	return res, nil
+}
+
+// DiffLineStatsInDirs calculates line stats for files in beforeDir and
+// afterDir (see CalcInDirParallel) and returns the difference
+func DiffLineStatsInDirs(beforeDir string, afterDir string, allowedFileFilter FilterFunc) (*LineStatsDiff, error) {
+	ctx := context.Background()
+	before := NewLineStats()
+	err := before.CalcInDirParallel(ctx, beforeDir, allowedFileFilter, 0)
+	if err != nil {
+		return nil, err
+	}
+	after := NewLineStats()
+	err = after.CalcInDirParallel(ctx, afterDir, allowedFileFilter, 0)
+	if err != nil {
+		return nil, err
+	}
+	return DiffLineStats(before, beforeDir, after, afterDir)
+}
+
+// DiffLineStatsGitRevisions returns difference in line stats between
+// two revisions of git repository in repoDir. Revisions are exported
+// to temporary directories (see GitExportRevision)
+func DiffLineStatsGitRevisions(repoDir string, beforeRev string, afterRev string, allowedFileFilter FilterFunc) (*LineStatsDiff, error) {
+	tmpDir, err := ioutil.TempDir("", "line-stats-diff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	beforeDir := filepath.Join(tmpDir, "before")
+	afterDir := filepath.Join(tmpDir, "after")
+	// we don't count lines in symlinks and they might point outside
+	// of the repository, which ExtractTar rejects
+	opts := &ExtractTarOptions{SkipSymlinks: true}
+	err = GitExportRevision(repoDir, beforeRev, beforeDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = GitExportRevision(repoDir, afterRev, afterDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return DiffLineStatsInDirs(beforeDir, afterDir, allowedFileFilter)
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sortLineStatsDiffRows(rows []*LineStatsDiffRow, sortBy LineStatsSortBy) {
+	sort.Slice(rows, func(i, j int) bool {
+		r1 := rows[i]
+		r2 := rows[j]
+		switch sortBy {
+		case LineStatsSortByCount:
+			// biggest change first
+			n1 := absInt(r1.Lines)
+			n2 := absInt(r2.Lines)
+			if n1 != n2 {
+				return n1 > n2
+			}
+		case LineStatsSortByExt:
+			if r1.ext != r2.ext {
+				return r1.ext < r2.ext
+			}
+		}
+		return r1.Name < r2.Name
+	})
+}
+
+// sorted returns a copy of d sorted and limited according to opts
+func (d *LineStatsDiff) sorted(opts *LineStatsOptions) *LineStatsDiff {
+	if opts == nil {
+		opts = &LineStatsOptions{}
+	}
+	res := &LineStatsDiff{
+		Files:  append([]*LineStatsDiffRow{}, d.Files...),
+		PerExt: append([]*LineStatsDiffRow{}, d.PerExt...),
+		Total:  d.Total,
+	}
+	sortLineStatsDiffRows(res.Files, opts.SortBy)
+	sortLineStatsDiffRows(res.PerExt, opts.SortBy)
+	if opts.TopN > 0 {
+		if len(res.Files) > opts.TopN {
+			res.Files = res.Files[:opts.TopN]
+		}
+		if len(res.PerExt) > opts.TopN {
+			res.PerExt = res.PerExt[:opts.TopN]
+		}
+	}
+	return res
+}
+
+// WriteLineStatsDiffJSON writes d as JSON. opts.BaseDir is ignored
+// (paths are already relative). opts can be nil
+func WriteLineStatsDiffJSON(w io.Writer, d *LineStatsDiff, opts *LineStatsOptions) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d.sorted(opts))
+}
+
+// WriteLineStatsDiffCSV writes d as CSV with columns:
+// kind, status, name, old_name, lines_before, lines_after, lines, code,
+// comment, blank. kind is one of "file", "ext", "total"
+func WriteLineStatsDiffCSV(w io.Writer, d *LineStatsDiff, opts *LineStatsOptions) error {
+	d = d.sorted(opts)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "status", "name", "old_name", "lines_before", "lines_after", "lines", "code", "comment", "blank"})
+	writeRows := func(kind string, rows []*LineStatsDiffRow) {
+		for _, row := range rows {
+			rec := []string{
+				kind,
+				row.Status,
+				row.Name,
+				row.OldName,
+				strconv.Itoa(row.LinesBefore),
+				strconv.Itoa(row.LinesAfter),
+				strconv.Itoa(row.Lines),
+				strconv.Itoa(row.Code),
+				strconv.Itoa(row.Comment),
+				strconv.Itoa(row.Blank),
+			}
+			cw.Write(rec)
+		}
+	}
+	writeRows("file", d.Files)
+	writeRows("ext", d.PerExt)
+	writeRows("total", []*LineStatsDiffRow{d.Total})
+	cw.Flush()
+	return cw.Error()
+}
+
+func lineStatsDiffName(row *LineStatsDiffRow) string {
+	if row.OldName != "" {
+		return row.OldName + " => " + row.Name
+	}
+	return row.Name
+}
+
+// WriteLineStatsDiffMarkdown writes d as Markdown tables
+func WriteLineStatsDiffMarkdown(w io.Writer, d *LineStatsDiff, opts *LineStatsOptions) error {
+	d = d.sorted(opts)
+	ew := &errWriter{w: w}
+	writeTable := func(title string, rows []*LineStatsDiffRow) {
+		ew.printf("## %s\n\n", title)
+		ew.printf("| name | status | before | after | lines | code | comment | blank |\n")
+		ew.printf("| --- | --- | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+		for _, r := range rows {
+			ew.printf("| %s | %s | %d | %d | %+d | %+d | %+d | %+d |\n", markdownEscapeCell(lineStatsDiffName(r)), r.Status, r.LinesBefore, r.LinesAfter, r.Lines, r.Code, r.Comment, r.Blank)
+		}
+		ew.printf("\n")
+	}
+	writeTable("Files", d.Files)
+	writeTable("Per extension", d.PerExt)
+	t := d.Total
+	ew.printf("**Total**: %d => %d lines, %+d (code: %+d, comment: %+d, blank: %+d)\n", t.LinesBefore, t.LinesAfter, t.Lines, t.Code, t.Comment, t.Blank)
+	return ew.err
+}
+
+// WriteLineStatsDiffText writes d as fixed-width text tables
+func WriteLineStatsDiffText(w io.Writer, d *LineStatsDiff, opts *LineStatsOptions) error {
+	d = d.sorted(opts)
+	ew := &errWriter{w: w}
+	writeTable := func(title string, rows []*LineStatsDiffRow) {
+		ew.printf("%s:\n", title)
+		ew.printf("%8s %8s %8s %8s %8s %8s  %-8s  %s\n", "before", "after", "lines", "code", "comment", "blank", "status", "name")
+		for _, r := range rows {
+			ew.printf("%8d %8d %+8d %+8d %+8d %+8d  %-8s  %s\n", r.LinesBefore, r.LinesAfter, r.Lines, r.Code, r.Comment, r.Blank, r.Status, lineStatsDiffName(r))
+		}
+		ew.printf("\n")
+	}
+	writeTable("Files", d.Files)
+	writeTable("Per extension", d.PerExt)
+	t := d.Total
+	ew.printf("total: %d => %d lines, %+d (code: %+d, comment: %+d, blank: %+d)\n", t.LinesBefore, t.LinesAfter, t.Lines, t.Code, t.Comment, t.Blank)
+	return ew.err
+}
@@ -0,0 +1,444 @@
+package u
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LangBlockComment describes a block comment like /* ... */
+type LangBlockComment struct {
+	Start string
+	End   string
+}
+
+// LangString describes a string literal
+type LangString struct {
+	Start string
+	End   string
+	// if true, \ escapes the next character
+	Escapes bool
+	// if true, the string can span multiple lines
+	Multiline bool
+}
+
+// Language describes comment and string syntax of a programming language,
+// for counting code and comment lines
+type Language struct {
+	Name string
+	// lower-case, with '.' e.g. ".go"
+	Exts          []string
+	LineComments  []string
+	BlockComments []LangBlockComment
+	// when multiple strings start with the same prefix, the longest must
+	// be first (e.g. `"""` before `"`)
+	Strings []LangString
+}
+
+var (
+	cBlockComments = []LangBlockComment{{"/*", "*/"}}
+	htmlComments   = []LangBlockComment{{"<!--", "-->"}}
+	cStrings       = []LangString{
+		{`"`, `"`, true, false},
+		{`'`, `'`, true, false},
+	}
+	jsStrings = []LangString{
+		{`"`, `"`, true, false},
+		{`'`, `'`, true, false},
+		{"`", "`", true, true},
+	}
+)
+
+// Languages is a list of languages we know about
+var Languages = []*Language{
+	{
+		Name:          "Go",
+		Exts:          []string{".go"},
+		LineComments:  []string{"//"},
+		BlockComments: cBlockComments,
+		Strings: []LangString{
+			{`"`, `"`, true, false},
+			{`'`, `'`, true, false},
+			// raw string
+			{"`", "`", false, true},
+		},
+	},
+	{
+		Name:          "C/C++",
+		Exts:          []string{".c", ".h", ".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx"},
+		LineComments:  []string{"//"},
+		BlockComments: cBlockComments,
+		Strings:       cStrings,
+	},
+	{
+		Name:          "JavaScript",
+		Exts:          []string{".js", ".mjs", ".cjs", ".jsx"},
+		LineComments:  []string{"//"},
+		BlockComments: cBlockComments,
+		Strings:       jsStrings,
+	},
+	{
+		Name:          "TypeScript",
+		Exts:          []string{".ts", ".tsx"},
+		LineComments:  []string{"//"},
+		BlockComments: cBlockComments,
+		Strings:       jsStrings,
+	},
+	{
+		Name:         "Python",
+		Exts:         []string{".py"},
+		LineComments: []string{"#"},
+		Strings: []LangString{
+			{`"""`, `"""`, true, true},
+			{`'''`, `'''`, true, true},
+			{`"`, `"`, true, false},
+			{`'`, `'`, true, false},
+		},
+	},
+	{
+		Name:         "Shell",
+		Exts:         []string{".sh", ".bash", ".zsh"},
+		LineComments: []string{"#"},
+		Strings: []LangString{
+			{`"`, `"`, true, true},
+			{`'`, `'`, false, true},
+		},
+	},
+	{
+		Name:          "HTML",
+		Exts:          []string{".html", ".htm"},
+		BlockComments: htmlComments,
+	},
+	{
+		Name:          "CSS",
+		Exts:          []string{".css"},
+		BlockComments: cBlockComments,
+		Strings:       cStrings,
+	},
+	{
+		Name:          "SQL",
+		Exts:          []string{".sql"},
+		LineComments:  []string{"--"},
+		BlockComments: cBlockComments,
+		// '' inside a string is the same as closing and re-opening it
+		Strings: []LangString{
+			{`'`, `'`, false, false},
+			{`"`, `"`, false, false},
+		},
+	},
+	{
+		Name:          "Markdown",
+		Exts:          []string{".md", ".markdown"},
+		BlockComments: htmlComments,
+	},
+}
+
+// LanguageForExt returns language for a file extension (e.g. ".go")
+// or nil if we don't know it
+func LanguageForExt(ext string) *Language {
+	ext = strings.ToLower(ext)
+	for _, lang := range Languages {
+		for _, e := range lang.Exts {
+			if e == ext {
+				return lang
+			}
+		}
+	}
+	return nil
+}
+
+// lineCounter counts lines in data written to it, without buffering
+// whole lines. It tracks state that spans lines (being inside a block
+// comment or a multi-line string) and state of the current line
+type lineCounter struct {
+	lang    *Language
+	inBlock *LangBlockComment
+	inStr   *LangString
+	// length of the longest comment or string marker. We don't look for
+	// markers closer than that to the end of data until we get more data
+	// or the line ends
+	lookahead int
+	// unprocessed end of the current line, shorter than lookahead
+	partial []byte
+	// true if previous chunk ended with '\r' so '\n' at the start of the
+	// next chunk is part of the same newline
+	afterCR bool
+	hasData bool
+	// true if the current line has any characters
+	inLine bool
+
+	// state of the current line
+	nonBlank   bool
+	hasCode    bool
+	hasComment bool
+
+	res LineCount
+}
+
+func newLineCounter(lang *Language) *lineCounter {
+	lc := &lineCounter{
+		lang: lang,
+		// for \ escapes
+		lookahead: 2,
+	}
+	if lang == nil {
+		return lc
+	}
+	markers := append([]string{}, lang.LineComments...)
+	for _, bc := range lang.BlockComments {
+		markers = append(markers, bc.Start, bc.End)
+	}
+	for _, s := range lang.Strings {
+		markers = append(markers, s.Start, s.End)
+	}
+	for _, m := range markers {
+		if len(m) > lc.lookahead {
+			lc.lookahead = len(m)
+		}
+	}
+	return lc
+}
+
+func isLineSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f' || c == '\v'
+}
+
+// Write implements io.Writer. \r\n and \r are treated like \n,
+// same as NormalizeNewlines
+func (lc *lineCounter) Write(d []byte) (int, error) {
+	n := len(d)
+	if n > 0 {
+		lc.hasData = true
+	}
+	for len(d) > 0 {
+		if lc.afterCR && d[0] == '\n' {
+			lc.afterCR = false
+			d = d[1:]
+			continue
+		}
+		lc.afterCR = false
+		idx := bytes.IndexAny(d, "\r\n")
+		if idx < 0 {
+			lc.feed(d, false)
+			break
+		}
+		lc.feed(d[:idx], true)
+		lc.endLine()
+		lc.afterCR = d[idx] == '\r'
+		d = d[idx+1:]
+	}
+	return n, nil
+}
+
+// feed processes a part of the current line. If lineEnd is false,
+// the end of d might be kept in lc.partial until we get more data
+func (lc *lineCounter) feed(d []byte, lineEnd bool) {
+	if len(d) > 0 {
+		lc.inLine = true
+	}
+	if len(lc.partial) > 0 {
+		lc.partial = append(lc.partial, d...)
+		d = lc.partial
+	}
+	n := lc.addToLine(d, lineEnd)
+	rest := d[n:]
+	if cap(lc.partial) < len(rest) {
+		lc.partial = make([]byte, 0, 64)
+	}
+	// copy handles overlap if d is lc.partial
+	lc.partial = lc.partial[:len(rest)]
+	copy(lc.partial, rest)
+}
+
+// addToLine processes a part of the current line and returns number
+// of bytes consumed. If lineEnd is true, it consumes all of line
+func (lc *lineCounter) addToLine(line []byte, lineEnd bool) int {
+	if !lc.nonBlank && len(bytes.TrimSpace(line)) > 0 {
+		lc.nonBlank = true
+	}
+	lang := lc.lang
+	n := len(line)
+	if lang == nil {
+		return n
+	}
+	i := 0
+	for i < n {
+		if !lineEnd && n-i < lc.lookahead {
+			return i
+		}
+		if lc.inBlock != nil {
+			lc.hasComment = true
+			end := lc.inBlock.End
+			idx := bytes.Index(line[i:], []byte(end))
+			if idx < 0 {
+				if lineEnd {
+					return n
+				}
+				// end marker might start in the last few bytes
+				if keep := n - len(end) + 1; keep > i {
+					i = keep
+				}
+				return i
+			}
+			i += idx + len(end)
+			lc.inBlock = nil
+			continue
+		}
+		if lc.inStr != nil {
+			lc.hasCode = true
+			if lc.inStr.Escapes && line[i] == '\\' {
+				i += 2
+				continue
+			}
+			if bytes.HasPrefix(line[i:], []byte(lc.inStr.End)) {
+				i += len(lc.inStr.End)
+				lc.inStr = nil
+				continue
+			}
+			i++
+			continue
+		}
+		if isLineSpace(line[i]) {
+			i++
+			continue
+		}
+		rest := line[i:]
+		// block comments are checked first because in some languages
+		// block comment can start with line comment marker
+		found := false
+		for j := range lang.BlockComments {
+			bc := &lang.BlockComments[j]
+			if bytes.HasPrefix(rest, []byte(bc.Start)) {
+				lc.inBlock = bc
+				lc.hasComment = true
+				i += len(bc.Start)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, s := range lang.LineComments {
+			if bytes.HasPrefix(rest, []byte(s)) {
+				// skip the rest of the line, including following parts
+				lc.hasComment = true
+				lc.inBlock = &lineCommentEnd
+				return n
+			}
+		}
+		lc.hasCode = true
+		for j := range lang.Strings {
+			ls := &lang.Strings[j]
+			if bytes.HasPrefix(rest, []byte(ls.Start)) {
+				lc.inStr = ls
+				i += len(ls.Start)
+				found = true
+				break
+			}
+		}
+		if !found {
+			i++
+		}
+	}
+	// i can be past n after \ at the end of line
+	return n
+}
+
+// line comment is treated as a block comment that ends at the end of line
+var lineCommentEnd = LangBlockComment{End: "\n"}
+
+func (lc *lineCounter) endLine() {
+	res := &lc.res
+	res.LineCount++
+	if !lc.nonBlank {
+		res.Blank++
+	} else if lc.lang == nil || lc.hasCode {
+		res.Code++
+	} else if lc.hasComment {
+		res.Comment++
+	} else {
+		res.Blank++
+	}
+	if lc.inBlock == &lineCommentEnd {
+		lc.inBlock = nil
+	}
+	if lc.inStr != nil && !lc.inStr.Multiline {
+		// unterminated string, most likely a syntax we don't understand
+		lc.inStr = nil
+	}
+	lc.inLine = false
+	lc.nonBlank = false
+	lc.hasCode = false
+	lc.hasComment = false
+}
+
+// finish returns the counts. An empty line after the final newline is
+// counted in LineCount, same as in FileLineCount, but not as blank
+func (lc *lineCounter) finish() *LineCount {
+	if lc.hasData {
+		if lc.inLine {
+			// process what we kept waiting for more data
+			lc.feed(nil, true)
+			lc.endLine()
+		} else {
+			lc.res.LineCount++
+		}
+		lc.hasData = false
+	}
+	res := lc.res
+	return &res
+}
+
+// CountLines returns line count of d broken down into code, comment and blank
+// lines. Lines that have both code and comment are counted as code.
+// If lang is nil, every non-blank line is code.
+// Name and Ext are not set
+func CountLines(d []byte, lang *Language) *LineCount {
+	lc := newLineCounter(lang)
+	lc.Write(d)
+	return lc.finish()
+}
+
+// CountLinesReader is like CountLines but reads data from r, without
+// reading all of it into memory
+func CountLinesReader(r io.Reader, lang *Language) (*LineCount, error) {
+	lc := newLineCounter(lang)
+	_, err := io.Copy(lc, r)
+	if err != nil {
+		return nil, err
+	}
+	return lc.finish(), nil
+}
+
+// FileLineCountDetailed returns line count of a file broken down into
+// code, comment and blank lines, based on language for its extension.
+// Also tells if the file looks binary or generated
+func FileLineCountDetailed(path string) (*LineCount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, fileSniffSize)
+	head, err := br.Peek(fileSniffSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// head is only valid until the next read from br
+	isBinary := IsBinaryData(head)
+	isGenerated := IsGeneratedData(path, head)
+	ext := strings.ToLower(filepath.Ext(path))
+	res, err := CountLinesReader(br, LanguageForExt(ext))
+	if err != nil {
+		return nil, err
+	}
+	res.Name = filepath.Base(path)
+	res.Ext = ext
+	res.Binary = isBinary
+	res.Generated = isGenerated
+	return res, nil
+}
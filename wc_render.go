@@ -0,0 +1,259 @@
+package u
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// LineStatsSortBy tells how to sort rows in LineStatsReport
+type LineStatsSortBy int
+
+const (
+	// largest line count first
+	LineStatsSortByCount LineStatsSortBy = iota
+	LineStatsSortByPath
+	// by extension, then by path
+	LineStatsSortByExt
+)
+
+// LineStatsOptions describes options for rendering LineStats
+type LineStatsOptions struct {
+	SortBy LineStatsSortBy
+	// if > 0, only first TopN rows (after sorting) of each list are shown.
+	// Total includes all files
+	TopN int
+	// if set, file and directory paths are shown relative to BaseDir
+	// and per directory summaries don't go above BaseDir
+	BaseDir string
+}
+
+// LineStatsRow is line count of a file or a summary of multiple files
+type LineStatsRow struct {
+	// file path, extension or directory
+	Name string `json:"name"`
+	// number of files
+	Files   int `json:"files"`
+	Lines   int `json:"lines"`
+	Code    int `json:"code"`
+	Comment int `json:"comment"`
+	Blank   int `json:"blank"`
+	ext     string
+}
+
+func (r *LineStatsRow) add(lc *LineCount) {
+	r.Files++
+	r.Lines += lc.LineCount
+	r.Code += lc.Code
+	r.Comment += lc.Comment
+	r.Blank += lc.Blank
+}
+
+// LineStatsReport is LineStats summarized per file, per extension
+// and per directory (files in it and in all its sub-directories)
+type LineStatsReport struct {
+	Files  []*LineStatsRow `json:"files"`
+	PerExt []*LineStatsRow `json:"per_ext"`
+	PerDir []*LineStatsRow `json:"per_dir"`
+	Total  *LineStatsRow   `json:"total"`
+}
+
+func sortLineStatsRows(rows []*LineStatsRow, sortBy LineStatsSortBy) {
+	sort.Slice(rows, func(i, j int) bool {
+		r1 := rows[i]
+		r2 := rows[j]
+		switch sortBy {
+		case LineStatsSortByCount:
+			if r1.Lines != r2.Lines {
+				return r1.Lines > r2.Lines
+			}
+		case LineStatsSortByExt:
+			if r1.ext != r2.ext {
+				return r1.ext < r2.ext
+			}
+		}
+		return r1.Name < r2.Name
+	})
+}
+
+func limitLineStatsRows(rows []*LineStatsRow, topN int) []*LineStatsRow {
+	if topN > 0 && len(rows) > topN {
+		return rows[:topN]
+	}
+	return rows
+}
+
+// NewLineStatsReport summarizes stats. opts can be nil
+func NewLineStatsReport(stats *LineStats, opts *LineStatsOptions) *LineStatsReport {
+	if opts == nil {
+		opts = &LineStatsOptions{}
+	}
+	relPath := func(path string) string {
+		if opts.BaseDir == "" {
+			return path
+		}
+		rel, err := filepath.Rel(opts.BaseDir, path)
+		if err != nil {
+			return path
+		}
+		return rel
+	}
+	res := &LineStatsReport{
+		Total: &LineStatsRow{Name: "total"},
+	}
+	baseDir := opts.BaseDir
+	if baseDir != "" {
+		baseDir = filepath.Clean(baseDir)
+	}
+	extToRow := map[string]*LineStatsRow{}
+	dirToRow := map[string]*LineStatsRow{}
+	for path, lc := range stats.FileToCount {
+		row := &LineStatsRow{
+			Name: relPath(path),
+			ext:  lc.Ext,
+		}
+		row.add(lc)
+		res.Files = append(res.Files, row)
+		res.Total.add(lc)
+
+		extRow := extToRow[lc.Ext]
+		if extRow == nil {
+			extRow = &LineStatsRow{Name: lc.Ext, ext: lc.Ext}
+			extToRow[lc.Ext] = extRow
+			res.PerExt = append(res.PerExt, extRow)
+		}
+		extRow.add(lc)
+
+		// add to every directory up to BaseDir (or root)
+		dir := filepath.Dir(path)
+		for {
+			name := relPath(dir)
+			dirRow := dirToRow[name]
+			if dirRow == nil {
+				dirRow = &LineStatsRow{Name: name}
+				dirToRow[name] = dirRow
+				res.PerDir = append(res.PerDir, dirRow)
+			}
+			dirRow.add(lc)
+			parent := filepath.Dir(dir)
+			if dir == baseDir || parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	sortLineStatsRows(res.Files, opts.SortBy)
+	sortLineStatsRows(res.PerExt, opts.SortBy)
+	sortLineStatsRows(res.PerDir, opts.SortBy)
+	res.Files = limitLineStatsRows(res.Files, opts.TopN)
+	res.PerExt = limitLineStatsRows(res.PerExt, opts.TopN)
+	res.PerDir = limitLineStatsRows(res.PerDir, opts.TopN)
+	return res
+}
+
+// WriteLineStatsJSON writes stats as JSON-serialized LineStatsReport
+func WriteLineStatsJSON(w io.Writer, stats *LineStats, opts *LineStatsOptions) error {
+	r := NewLineStatsReport(stats, opts)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteLineStatsCSV writes stats as CSV with columns:
+// kind, name, files, lines, code, comment, blank.
+// kind is one of "file", "ext", "dir", "total"
+func WriteLineStatsCSV(w io.Writer, stats *LineStats, opts *LineStatsOptions) error {
+	r := NewLineStatsReport(stats, opts)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "name", "files", "lines", "code", "comment", "blank"})
+	writeRows := func(kind string, rows []*LineStatsRow) {
+		for _, row := range rows {
+			rec := []string{
+				kind,
+				row.Name,
+				strconv.Itoa(row.Files),
+				strconv.Itoa(row.Lines),
+				strconv.Itoa(row.Code),
+				strconv.Itoa(row.Comment),
+				strconv.Itoa(row.Blank),
+			}
+			cw.Write(rec)
+		}
+	}
+	writeRows("file", r.Files)
+	writeRows("ext", r.PerExt)
+	writeRows("dir", r.PerDir)
+	writeRows("total", []*LineStatsRow{r.Total})
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLineStatsMarkdown writes stats as Markdown tables
+func WriteLineStatsMarkdown(w io.Writer, stats *LineStats, opts *LineStatsOptions) error {
+	r := NewLineStatsReport(stats, opts)
+	ew := &errWriter{w: w}
+	writeTable := func(title string, rows []*LineStatsRow) {
+		ew.printf("## %s\n\n", title)
+		ew.printf("| name | files | lines | code | comment | blank |\n")
+		ew.printf("| --- | ---: | ---: | ---: | ---: | ---: |\n")
+		for _, row := range rows {
+			ew.printf("| %s | %d | %d | %d | %d | %d |\n", markdownEscapeCell(row.Name), row.Files, row.Lines, row.Code, row.Comment, row.Blank)
+		}
+		ew.printf("\n")
+	}
+	writeTable("Files", r.Files)
+	writeTable("Per extension", r.PerExt)
+	writeTable("Per directory", r.PerDir)
+	t := r.Total
+	ew.printf("**Total**: %d files, %d lines (code: %d, comment: %d, blank: %d)\n", t.Files, t.Lines, t.Code, t.Comment, t.Blank)
+	return ew.err
+}
+
+// WriteLineStatsText writes stats as fixed-width text tables
+func WriteLineStatsText(w io.Writer, stats *LineStats, opts *LineStatsOptions) error {
+	r := NewLineStatsReport(stats, opts)
+	ew := &errWriter{w: w}
+	writeTable := func(title string, rows []*LineStatsRow) {
+		ew.printf("%s:\n", title)
+		ew.printf("%8s %8s %8s %8s %8s  %s\n", "files", "lines", "code", "comment", "blank", "name")
+		for _, row := range rows {
+			ew.printf("%8d %8d %8d %8d %8d  %s\n", row.Files, row.Lines, row.Code, row.Comment, row.Blank, row.Name)
+		}
+		ew.printf("\n")
+	}
+	writeTable("Files", r.Files)
+	writeTable("Per extension", r.PerExt)
+	writeTable("Per directory", r.PerDir)
+	t := r.Total
+	ew.printf("total: %d files, %d lines (code: %d, comment: %d, blank: %d)\n", t.Files, t.Lines, t.Code, t.Comment, t.Blank)
+	return ew.err
+}
+
+// errWriter remembers the first write error so that we can check it once
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
+
+func markdownEscapeCell(s string) string {
+	var res []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '|' || c == '\\' {
+			res = append(res, '\\')
+		}
+		res = append(res, c)
+	}
+	return string(res)
+}
@@ -0,0 +1,286 @@
+package u
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCountLines(t *testing.T, ext string, s string, code, comment, blank int) {
+	lc := CountLines([]byte(s), LanguageForExt(ext))
+	assert.Equal(t, code, lc.Code, "code in %s", s)
+	assert.Equal(t, comment, lc.Comment, "comment in %s", s)
+	assert.Equal(t, blank, lc.Blank, "blank in %s", s)
+	nLines := lc.Code + lc.Comment + lc.Blank
+	// empty line after the final newline is only counted in LineCount
+	if strings.HasSuffix(s, "\n") {
+		nLines++
+	}
+	assert.Equal(t, nLines, lc.LineCount)
+}
+
+func TestCountLines(t *testing.T) {
+	goSrc := `package u
+
+// comment
+/* block
+   comment */
+var s = "/* not a comment" // trailing comment
+var r = ` + "`" + `raw
+// not a comment
+` + "`" + `
+/* a */ x := 1
+`
+	testCountLines(t, ".go", goSrc, 6, 3, 1)
+
+	pySrc := "# comment\nx = '#'\n\"\"\"\n# in docstring\n\"\"\"\n"
+	testCountLines(t, ".py", pySrc, 4, 1, 0)
+
+	testCountLines(t, ".sql", "-- comment\nselect 'it''s -- here'\n", 1, 1, 0)
+	testCountLines(t, ".html", "<!-- a\nb -->\n<p>don't</p>", 1, 2, 0)
+	testCountLines(t, ".js", "const s = `\n// x\n`; /* y */\n", 3, 0, 0)
+	testCountLines(t, ".c", "char c = '\"'; // \"\n/* x */", 1, 1, 0)
+	testCountLines(t, ".unknown", "// x\n\ny", 2, 0, 1)
+	testCountLines(t, ".go", "", 0, 0, 0)
+	testCountLines(t, ".go", "x", 1, 0, 0)
+	testCountLines(t, ".go", "package u\n\n", 1, 0, 1)
+
+	// the last line is empty, as in FileLineCount
+	lc := CountLines([]byte("package u\n"), LanguageForExt(".go"))
+	assert.Equal(t, 2, lc.LineCount)
+	assert.Equal(t, 0, lc.Blank)
+
+	assert.Nil(t, LanguageForExt(".unknown"))
+	assert.Equal(t, "Go", LanguageForExt(".GO").Name)
+}
+
+func testLineStats() *LineStats {
+	s := NewLineStats()
+	s.FileToCount["/src/a.go"] = &LineCount{Name: "a.go", Ext: ".go", LineCount: 10, Code: 7, Comment: 2, Blank: 1}
+	s.FileToCount["/src/sub/b.go"] = &LineCount{Name: "b.go", Ext: ".go", LineCount: 30, Code: 30}
+	s.FileToCount["/src/sub/c.js"] = &LineCount{Name: "c.js", Ext: ".js", LineCount: 20, Code: 10, Blank: 10}
+	return s
+}
+
+func TestLineStatsReport(t *testing.T) {
+	s := testLineStats()
+	r := NewLineStatsReport(s, &LineStatsOptions{BaseDir: "/src", TopN: 2})
+	assert.Equal(t, 2, len(r.Files))
+	assert.Equal(t, filepath.Join("sub", "b.go"), r.Files[0].Name)
+	assert.Equal(t, filepath.Join("sub", "c.js"), r.Files[1].Name)
+	assert.Equal(t, ".go", r.PerExt[0].Name)
+	assert.Equal(t, 2, r.PerExt[0].Files)
+	assert.Equal(t, 40, r.PerExt[0].Lines)
+	assert.Equal(t, 2, len(r.PerDir))
+	assert.Equal(t, ".", r.PerDir[0].Name)
+	assert.Equal(t, 60, r.PerDir[0].Lines)
+	assert.Equal(t, 3, r.PerDir[0].Files)
+	assert.Equal(t, "sub", r.PerDir[1].Name)
+	assert.Equal(t, 50, r.PerDir[1].Lines)
+	assert.Equal(t, 60, r.Total.Lines)
+	assert.Equal(t, 3, r.Total.Files)
+
+	r = NewLineStatsReport(s, &LineStatsOptions{SortBy: LineStatsSortByExt})
+	assert.Equal(t, "/src/sub/c.js", r.Files[2].Name)
+	r = NewLineStatsReport(s, &LineStatsOptions{SortBy: LineStatsSortByPath})
+	assert.Equal(t, "/src/a.go", r.Files[0].Name)
+}
+
+func TestWriteLineStats(t *testing.T) {
+	s := testLineStats()
+	opts := &LineStatsOptions{BaseDir: "/src", SortBy: LineStatsSortByPath}
+	var buf bytes.Buffer
+	err := WriteLineStatsCSV(&buf, s, opts)
+	assert.Nil(t, err)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "kind,name,files,lines,code,comment,blank", lines[0])
+	assert.Equal(t, "file,a.go,1,10,7,2,1", lines[1])
+	assert.Equal(t, "total,total,3,60,47,2,11", lines[len(lines)-2])
+
+	buf.Reset()
+	err = WriteLineStatsJSON(&buf, s, opts)
+	assert.Nil(t, err)
+	var r LineStatsReport
+	err = json.Unmarshal(buf.Bytes(), &r)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(r.Files))
+	assert.Equal(t, 47, r.Total.Code)
+
+	buf.Reset()
+	err = WriteLineStatsMarkdown(&buf, s, opts)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "| a.go | 1 | 10 | 7 | 2 | 1 |\n")
+
+	buf.Reset()
+	err = WriteLineStatsText(&buf, s, opts)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "total: 3 files, 60 lines")
+}
+
+func TestCountLinesReader(t *testing.T) {
+	s := "// a\r\nx := `b\r\n\r\n` /* c */\rfoo()\n"
+	exp := CountLines([]byte(s), LanguageForExt(".go"))
+	assert.Equal(t, 6, exp.LineCount)
+	assert.Equal(t, 3, exp.Code)
+	// feed one byte at a time to check state kept between writes
+	lc := newLineCounter(LanguageForExt(".go"))
+	for i := 0; i < len(s); i++ {
+		lc.Write([]byte{s[i]})
+	}
+	assert.Equal(t, exp, lc.finish())
+
+	got, err := CountLinesReader(strings.NewReader(s), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, got.LineCount)
+}
+
+func TestCalcInDirParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-wc-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":         "package a\n\n// comment\n",
+		"sub/b.js":     "x = 1;\n/* y */\n",
+		"sub/sub/c.go": "package c",
+		"d.txt":        "skipped",
+	}
+	for name, content := range files {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(dir, name))
+		assert.Nil(t, err)
+	}
+	filter := MakeAllowedFileFilterForExts(".go", ".js")
+	exp := NewLineStats()
+	err = exp.CalcInDir(dir, filter, true)
+	assert.Nil(t, err)
+	got := NewLineStats()
+	err = got.CalcInDirParallel(context.Background(), dir, filter, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(got.FileToCount))
+	assert.Equal(t, exp.FileToCount, got.FileToCount)
+
+	err = got.CalcInDirParallel(context.Background(), filepath.Join(dir, "missing"), nil, 2)
+	assert.NotNil(t, err)
+	_, ok := err.(MultiError)
+	assert.True(t, ok)
+
+	err = got.CalcInDir(filepath.Join(dir, "missing"), filter, true)
+	assert.NotNil(t, err)
+	_, ok = err.(MultiError)
+	assert.True(t, ok)
+}
+
+func TestDiffLineStatsInDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-wc-diff-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	before := map[string]string{
+		"a.go":     "package a\n",
+		"old.js":   "x = 1;\ny = 2;\n",
+		"gone.go":  "package gone\n\n",
+		"same.go":  "package same\n",
+		"moved.go": "package moved\n// comment\n",
+	}
+	after := map[string]string{
+		"a.go":         "package a\n\nfunc f() {}\n",
+		"new.js":       "z = 3;\n",
+		"same.go":      "package same\n",
+		"sub/moved.go": "package moved\n// comment\n",
+	}
+	beforeDir := filepath.Join(dir, "before")
+	afterDir := filepath.Join(dir, "after")
+	for name, content := range before {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(beforeDir, name))
+		assert.Nil(t, err)
+	}
+	for name, content := range after {
+		err = WriteFileCreateDirMust([]byte(content), filepath.Join(afterDir, name))
+		assert.Nil(t, err)
+	}
+	d, err := DiffLineStatsInDirs(beforeDir, afterDir, nil)
+	assert.Nil(t, err)
+	d = d.sorted(&LineStatsOptions{SortBy: LineStatsSortByPath})
+	var got []string
+	for _, r := range d.Files {
+		got = append(got, r.Status+" "+lineStatsDiffName(r)+" "+strconv.Itoa(r.Lines))
+	}
+	exp := []string{
+		"modified a.go 2",
+		"removed gone.go -3",
+		"added new.js 2",
+		"removed old.js -3",
+		"renamed moved.go => sub/moved.go 0",
+	}
+	assert.Equal(t, exp, got)
+	assert.Equal(t, ".go", d.PerExt[0].Name)
+	assert.Equal(t, -1, d.PerExt[0].Lines)
+	assert.Equal(t, -1, d.PerExt[1].Lines)
+	assert.Equal(t, -2, d.Total.Lines)
+
+	var buf bytes.Buffer
+	err = WriteLineStatsDiffCSV(&buf, d, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "file,renamed,sub/moved.go,moved.go,3,3,0,0,0,0\n")
+	buf.Reset()
+	err = WriteLineStatsDiffText(&buf, d, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "total: 13 => 11 lines, -2")
+	buf.Reset()
+	err = WriteLineStatsDiffMarkdown(&buf, d, &LineStatsOptions{TopN: 1})
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "| gone.go | removed | 3 | 0 | -3 |")
+	buf.Reset()
+	err = WriteLineStatsDiffJSON(&buf, d, nil)
+	assert.Nil(t, err)
+	var d2 LineStatsDiff
+	err = json.Unmarshal(buf.Bytes(), &d2)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(d2.Files))
+}
+
+func TestDiffLineStatsGitRevisions(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "u-wc-git-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+	git("init", "-q")
+	err = WriteFileCreateDirMust([]byte("package a\n"), filepath.Join(dir, "a.go"))
+	assert.Nil(t, err)
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	err = WriteFileCreateDirMust([]byte("package a\n\nvar x = 1\n"), filepath.Join(dir, "a.go"))
+	assert.Nil(t, err)
+	// symlinks are skipped, even if they point outside of the repo
+	err = os.Symlink(filepath.Join("..", "outside"), filepath.Join(dir, "link"))
+	assert.Nil(t, err)
+	git("add", "-A")
+	git("commit", "-q", "-m", "second")
+
+	d, err := DiffLineStatsGitRevisions(dir, "HEAD~1", "HEAD", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(d.Files))
+	assert.Equal(t, 2, d.Total.Lines)
+	assert.Equal(t, 1, d.Total.Code)
+
+	_, err = DiffLineStatsGitRevisions(dir, "no-such-rev", "HEAD", nil)
+	assert.NotNil(t, err)
+}
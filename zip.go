@@ -0,0 +1,631 @@
+package u
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ZipSymlinkPolicy tells CreateZipFileWithOptions what to do with symlinks
+type ZipSymlinkPolicy int
+
+const (
+	// ZipSymlinkError returns an error when a symlink is encountered
+	ZipSymlinkError ZipSymlinkPolicy = iota
+	// ZipSymlinkSkip doesn't add symlinks to the archive
+	ZipSymlinkSkip
+	// ZipSymlinkFollow adds files and directories symlinks point to
+	ZipSymlinkFollow
+	// ZipSymlinkStore stores symlinks as symlinks
+	ZipSymlinkStore
+)
+
+// DefaultZipStoreExts is a list of extensions of files that are already
+// compressed so CreateZipFileWithOptions stores them without compression
+var DefaultZipStoreExts = []string{
+	".7z", ".br", ".bz2", ".gz", ".tgz", ".xz", ".zip", ".zst",
+	".avif", ".gif", ".jpeg", ".jpg", ".png", ".webp",
+	".mp3", ".mp4", ".ogg", ".webm",
+	".woff", ".woff2",
+}
+
+// CreateZipOptions describes options for CreateZipFileWithOptions
+type CreateZipOptions struct {
+	// if set, only files for which Filter returns true are added.
+	// Filter is called with path relative to baseDir
+	Filter FilterFunc
+	// what to do with symlinks. Default is to return an error
+	Symlinks ZipSymlinkPolicy
+	// if true, we don't print added files
+	Silent bool
+	// files with those extensions are stored without compression.
+	// If nil, DefaultZipStoreExts is used
+	StoreExts []string
+	// if true, the result only depends on names and content of files
+	// (and executable bit), so zipping the same tree twice creates
+	// byte-identical files
+	Reproducible bool
+	// if true, dst is written atomically (see WriteAtomically)
+	Atomic bool
+}
+
+// zipSrcFile is a file to be added to a zip archive
+type zipSrcFile struct {
+	name string // name inside the archive, with '/' as separator
+	path string // path on disk
+	fi   os.FileInfo
+	link string // target of symlink if stored as symlink
+}
+
+// time of all entries in reproducible zip files. It's the earliest time
+// that can be represented in zip's DOS date format
+var zipReproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type zipCollector struct {
+	opts    *CreateZipOptions
+	entries []*zipSrcFile
+	// real paths of directories being visited, to detect symlink cycles
+	visiting map[string]bool
+}
+
+func (c *zipCollector) addPath(name string, path string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch c.opts.Symlinks {
+		case ZipSymlinkSkip:
+			return nil
+		case ZipSymlinkStore:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return c.addFile(name, path, fi, link)
+		case ZipSymlinkFollow:
+			var err error
+			fi, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s is a symlink", path)
+		}
+	}
+	if fi.IsDir() {
+		return c.addDir(name, path)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a dir or regular file", path)
+	}
+	return c.addFile(name, path, fi, "")
+}
+
+func (c *zipCollector) addFile(name string, path string, fi os.FileInfo, link string) error {
+	if c.opts.Filter != nil && !c.opts.Filter(name) {
+		return nil
+	}
+	e := &zipSrcFile{
+		name: filepath.ToSlash(name),
+		path: path,
+		fi:   fi,
+		link: link,
+	}
+	c.entries = append(c.entries, e)
+	return nil
+}
+
+func (c *zipCollector) addDir(name string, dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if c.visiting[realDir] {
+		return fmt.Errorf("symlink cycle at %s", dir)
+	}
+	c.visiting[realDir] = true
+	defer delete(c.visiting, realDir)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		err = c.addPath(filepath.Join(name, fi.Name()), filepath.Join(dir, fi.Name()), fi)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipCollectFiles returns files in toZip (relative to baseDir),
+// recursively for directories
+func zipCollectFiles(baseDir string, toZip []string, opts *CreateZipOptions) ([]*zipSrcFile, error) {
+	c := &zipCollector{
+		opts:     opts,
+		visiting: map[string]bool{},
+	}
+	for _, name := range toZip {
+		path := filepath.Join(baseDir, name)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		if name == "." {
+			name = ""
+		}
+		err = c.addPath(name, path, fi)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.entries, nil
+}
+
+func zipEntryHeader(e *zipSrcFile, opts *CreateZipOptions) *zip.FileHeader {
+	storeExts := opts.StoreExts
+	if storeExts == nil {
+		storeExts = DefaultZipStoreExts
+	}
+	hdr := &zip.FileHeader{
+		Name:   e.name,
+		Method: zip.Deflate,
+	}
+	// note: PathMatchesExtensions matches everything for empty list
+	if e.link != "" || (len(storeExts) > 0 && PathMatchesExtensions(e.name, storeExts)) {
+		hdr.Method = zip.Store
+	}
+	if opts.Reproducible {
+		// normalize permissions to 0644 or 0755 (for executables)
+		mode := os.FileMode(0644)
+		if e.fi.Mode().Perm()&0100 != 0 {
+			mode = 0755
+		}
+		if e.link != "" {
+			mode = os.ModeSymlink | 0777
+		}
+		hdr.Modified = zipReproducibleTime
+		hdr.SetMode(mode)
+		return hdr
+	}
+	hdr.Modified = e.fi.ModTime()
+	hdr.SetMode(e.fi.Mode())
+	return hdr
+}
+
+func writeZip(w io.Writer, entries []*zipSrcFile, opts *CreateZipOptions) error {
+	zw := zip.NewWriter(w)
+	if opts.Reproducible {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].name < entries[j].name
+		})
+		// don't depend on default compression level changing
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		})
+	}
+	for _, e := range entries {
+		hdr := zipEntryHeader(e, opts)
+		var err error
+		if e.link != "" {
+			var w io.Writer
+			w, err = zw.CreateHeader(hdr)
+			if err == nil {
+				// by convention content of symlink entry is its target
+				_, err = w.Write([]byte(filepath.ToSlash(e.link)))
+			}
+		} else {
+			err = zipCopyFile(zw, hdr, e.path)
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.Silent {
+			fmt.Printf("  added %s from %s\n", e.name, e.path)
+		}
+	}
+	return zw.Close()
+}
+
+func zipCopyFile(zw *zip.Writer, hdr *zip.FileHeader, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// CreateZipFileWithOptions is like CreateZipFile but returns an error
+// instead of panicking and can be customized with opts (which can be nil).
+// toZip is a list of files and directories in baseDir
+// Directories are added recursively.
+func CreateZipFileWithOptions(dst string, baseDir string, opts *CreateZipOptions, toZip ...string) error {
+	if len(toZip) == 0 {
+		return fmt.Errorf("must provide toZip args")
+	}
+	if opts == nil {
+		opts = &CreateZipOptions{}
+	}
+	entries, err := zipCollectFiles(baseDir, toZip, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Atomic {
+		return WriteAtomically(dst, 0644, func(w io.Writer) error {
+			return writeZip(w, entries, opts)
+		})
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = writeZip(f, entries, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// CreateZipFileReproducible is like CreateZipFile but the result only
+// depends on names and content of files (and executable bit), so zipping
+// the same tree twice creates byte-identical files
+func CreateZipFileReproducible(dst string, baseDir string, toZip ...string) {
+	opts := &CreateZipOptions{
+		Reproducible: true,
+	}
+	err := CreateZipFileWithOptions(dst, baseDir, opts, toZip...)
+	Must(err)
+}
+
+// CreateZipWithDirContentReproducible is like CreateZipWithDirContent but
+// creates reproducible zip files (see CreateZipFileReproducible)
+func CreateZipWithDirContentReproducible(zipFilePath, dirToZip string) error {
+	isDir, err := PathIsDir(dirToZip)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		return fmt.Errorf("'%s' is not a directory", dirToZip)
+	}
+	opts := &CreateZipOptions{
+		Reproducible: true,
+		Silent:       true,
+	}
+	return CreateZipFileWithOptions(zipFilePath, dirToZip, opts, ".")
+}
+
+// ZipEntry describes a file in a zip archive
+type ZipEntry struct {
+	Name string
+	// uncompressed size
+	Size           int64
+	CompressedSize int64
+	Mode           os.FileMode
+	ModTime        time.Time
+	f              *zip.File
+}
+
+// Open returns a reader for uncompressed content of the entry
+func (e *ZipEntry) Open() (io.ReadCloser, error) {
+	return e.f.Open()
+}
+
+// ReadAll returns uncompressed content of the entry
+func (e *ZipEntry) ReadAll() ([]byte, error) {
+	rc, err := e.f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// ZipReader reads entries of zip archive on demand, without reading
+// the whole archive into memory
+type ZipReader struct {
+	entries []*ZipEntry
+	zr      *zip.Reader
+	closer  io.Closer
+}
+
+func newZipReader(zr *zip.Reader, closer io.Closer) *ZipReader {
+	res := &ZipReader{
+		zr:     zr,
+		closer: closer,
+	}
+	for _, f := range zr.File {
+		e := &ZipEntry{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Mode:           f.Mode(),
+			ModTime:        f.Modified,
+			f:              f,
+		}
+		res.entries = append(res.entries, e)
+	}
+	return res
+}
+
+// OpenZipFile opens zip archive for reading. Must call Close() when done
+func OpenZipFile(path string) (*ZipReader, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return newZipReader(&r.Reader, r), nil
+}
+
+// NewZipReaderAt returns ZipReader for zip archive of a given size in r
+// e.g. a bytes.Reader or an object returned by MinioClient
+func NewZipReaderAt(r io.ReaderAt, size int64) (*ZipReader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var closer io.Closer
+	if c, ok := r.(io.Closer); ok {
+		closer = c
+	}
+	return newZipReader(zr, closer), nil
+}
+
+// Close closes underlying file (or io.ReaderAt if it implements io.Closer)
+func (r *ZipReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Entries returns entries in the order they are in the archive
+func (r *ZipReader) Entries() []*ZipEntry {
+	return r.entries
+}
+
+// Iter calls fn for each entry in archive order.
+// Stops and returns the error if fn returns an error
+func (r *ZipReader) Iter(fn func(e *ZipEntry) error) error {
+	for _, e := range r.entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns entry with a given name or nil if doesn't exist
+func (r *ZipReader) Find(name string) *ZipEntry {
+	for _, e := range r.entries {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// ReadEntry returns uncompressed content of entry with a given name
+func (r *ZipReader) ReadEntry(name string) ([]byte, error) {
+	e := r.Find(name)
+	if e == nil {
+		return nil, fmt.Errorf("zip entry '%s' doesn't exist", name)
+	}
+	return e.ReadAll()
+}
+
+// Extract extracts the archive to dstDir. See ExtractZip
+func (r *ZipReader) Extract(dstDir string, opts *ExtractZipOptions) error {
+	return extractZipReader(dstDir, r.zr, opts)
+}
+
+// IterZipFile calls fn for each entry in zip archive at path
+func IterZipFile(path string, fn func(e *ZipEntry) error) error {
+	r, err := OpenZipFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return r.Iter(fn)
+}
+
+// ReadZipEntry returns content of a single entry in zip archive at path
+func ReadZipEntry(path string, name string) ([]byte, error) {
+	r, err := OpenZipFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.ReadEntry(name)
+}
+
+// ExtractZipOptions describes limits for ExtractZip
+type ExtractZipOptions struct {
+	// maximum total size of uncompressed data. 0 means the limit from
+	// DefaultExtractZipOptions, < 0 means no limit
+	MaxTotalSize int64
+	// maximum number of entries in the archive. 0 means the limit from
+	// DefaultExtractZipOptions, < 0 means no limit
+	MaxEntries int
+	// if true, symlink entries are created if they point inside
+	// destination directory. If false, archives with symlinks are rejected
+	AllowSymlinks bool
+}
+
+// DefaultExtractZipOptions are used by ExtractZip if opts is nil
+var DefaultExtractZipOptions = ExtractZipOptions{
+	MaxTotalSize: 4 << 30,
+	MaxEntries:   100000,
+}
+
+var errZipTooLarge = errors.New("zip archive exceeds uncompressed size limit")
+
+// ExtractZip extracts zip file zipPath into dstDir.
+// Entries that would be written outside of dstDir are rejected.
+// Restores file modes and modification times.
+// If opts is nil, DefaultExtractZipOptions are used.
+func ExtractZip(dstDir string, zipPath string, opts *ExtractZipOptions) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extractZipReader(dstDir, &r.Reader, opts)
+}
+
+func extractZipReader(dstDir string, zr *zip.Reader, opts *ExtractZipOptions) error {
+	if opts == nil {
+		opts = &DefaultExtractZipOptions
+	}
+	maxTotalSize := opts.MaxTotalSize
+	if maxTotalSize == 0 {
+		maxTotalSize = DefaultExtractZipOptions.MaxTotalSize
+	}
+	maxEntries := opts.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = DefaultExtractZipOptions.MaxEntries
+	}
+	if maxEntries > 0 && len(zr.File) > maxEntries {
+		return fmt.Errorf("zip archive has %d entries, limit is %d", len(zr.File), maxEntries)
+	}
+	// fail early based on sizes in the headers. They can lie so we also
+	// limit amount of data written
+	var totalSize uint64
+	for _, f := range zr.File {
+		totalSize += f.UncompressedSize64
+	}
+	if maxTotalSize > 0 && totalSize > uint64(maxTotalSize) {
+		return errZipTooLarge
+	}
+	err := CreateDir(dstDir)
+	if err != nil {
+		return err
+	}
+	left := maxTotalSize
+	// permissions and mtime of directories are set after we're done
+	// writing files in them (directory might be read-only)
+	var dirs []dirTimes
+	for _, f := range zr.File {
+		path, err := safeJoin(dstDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if err = ensureNoSymlinkInPath(dstDir, path); err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			perm := mode.Perm()
+			if perm == 0 {
+				perm = 0755
+			}
+			err = os.MkdirAll(path, 0755)
+			dirs = append(dirs, dirTimes{path, f.Modified, perm})
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(dstDir, path, f, opts)
+		case mode.IsRegular():
+			var n int64
+			n, err = extractZipFile(path, f, left, maxTotalSize > 0)
+			left -= n
+		default:
+			err = fmt.Errorf("zip entry '%s' has unsupported type", f.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return setDirTimes(dirs)
+}
+
+// extractZipFile streams a zip entry to path. If limit is true, writes
+// at most left bytes. Returns number of bytes written
+func extractZipFile(path string, f *zip.File, left int64, limit bool) (int64, error) {
+	perm := f.Mode().Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	err = CreateDirForFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// remove first so that we don't write through existing symlink
+	os.Remove(path)
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return 0, err
+	}
+	var r io.Reader = rc
+	if limit {
+		// read one more byte than allowed to detect going over the limit
+		r = io.LimitReader(rc, left+1)
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && limit && n > left {
+		err = errZipTooLarge
+	}
+	if err != nil {
+		w.Close()
+		os.Remove(path)
+		return n, err
+	}
+	err = w.Close()
+	if err != nil {
+		return n, err
+	}
+	// OpenFile applies umask to perm
+	err = os.Chmod(path, perm)
+	if err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(path, f.Modified, f.Modified)
+}
+
+func extractZipSymlink(dstDir string, path string, f *zip.File, opts *ExtractZipOptions) error {
+	if !opts.AllowSymlinks {
+		return fmt.Errorf("zip entry '%s' is a symlink", f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	// content of symlink entry is the target. no valid target is this long
+	d, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	target := string(d)
+	err = CreateDirForFile(path)
+	if err != nil {
+		return err
+	}
+	err = checkSymlinkTarget(dstDir, f.Name, path, target)
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	return os.Symlink(target, path)
+}
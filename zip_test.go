@@ -0,0 +1,286 @@
+package u
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateZipReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	for _, name := range []string{"b.txt", "a.txt", "sub/c.txt"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(srcDir, name))
+		assert.Nil(t, err)
+	}
+	zip1 := filepath.Join(dir, "1.zip")
+	err = CreateZipWithDirContentReproducible(zip1, srcDir)
+	assert.Nil(t, err)
+
+	mtime := time.Now().Add(-time.Hour)
+	err = os.Chtimes(filepath.Join(srcDir, "a.txt"), mtime, mtime)
+	assert.Nil(t, err)
+	zip2 := filepath.Join(dir, "2.zip")
+	err = CreateZipWithDirContentReproducible(zip2, srcDir)
+	assert.Nil(t, err)
+	assert.True(t, AreFilesEuqalMust(zip1, zip2))
+
+	files := ReadZipFileMust(zip1)
+	assert.Equal(t, "sub/c.txt", string(files["sub/c.txt"]))
+}
+
+func TestCreateZipFileWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	for _, name := range []string{"a.txt", "img.png", "node_modules/b.js"} {
+		err = WriteFileCreateDirMust([]byte(name), filepath.Join(srcDir, name))
+		assert.Nil(t, err)
+	}
+	err = os.Symlink("a.txt", filepath.Join(srcDir, "link.txt"))
+	assert.Nil(t, err)
+
+	zipPath := filepath.Join(dir, "out.zip")
+	opts := &CreateZipOptions{Silent: true}
+	err = CreateZipFileWithOptions(zipPath, srcDir, opts, ".")
+	assert.Error(t, err, "symlinks should be rejected by default")
+
+	opts = &CreateZipOptions{
+		Filter:   MakeExcludeDirsFilter("node_modules"),
+		Symlinks: ZipSymlinkFollow,
+		Silent:   true,
+	}
+	err = CreateZipFileWithOptions(zipPath, srcDir, opts, ".")
+	assert.Nil(t, err)
+	r, err := zip.OpenReader(zipPath)
+	assert.Nil(t, err)
+	methods := map[string]uint16{}
+	for _, f := range r.File {
+		methods[f.Name] = f.Method
+	}
+	r.Close()
+	exp := map[string]uint16{
+		"a.txt":    zip.Deflate,
+		"img.png":  zip.Store,
+		"link.txt": zip.Deflate,
+	}
+	assert.Equal(t, exp, methods)
+}
+
+func TestZipReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	zipPath := filepath.Join(dir, "test.zip")
+	writeTestZip(t, zipPath, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+	})
+	var names []string
+	err = IterZipFile(zipPath, func(e *ZipEntry) error {
+		names = append(names, e.Name)
+		if e.Name == "sub/b.txt" {
+			assert.Equal(t, int64(2), e.Size)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(names))
+
+	d, err := ReadZipEntry(zipPath, "sub/b.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "bb", string(d))
+	_, err = ReadZipEntry(zipPath, "missing.txt")
+	assert.Error(t, err)
+
+	zipData := ReadFileMust(zipPath)
+	r, err := NewZipReaderAt(bytes.NewReader(zipData), int64(len(zipData)))
+	assert.Nil(t, err)
+	d, err = r.ReadEntry("a.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(d))
+	assert.Nil(t, r.Close())
+}
+
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, f.Close())
+}
+
+// writeTestZipSymlinks writes zip with symlinks, in order, as name => target
+func writeTestZipSymlinks(t *testing.T, path string, links [][2]string) {
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	zw := zip.NewWriter(f)
+	for _, link := range links {
+		fh := &zip.FileHeader{Name: link[0]}
+		fh.SetMode(os.ModeSymlink | 0777)
+		w, err := zw.CreateHeader(fh)
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(link[1]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, f.Close())
+}
+
+func TestExtractZipSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-symlink-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := &ExtractZipOptions{AllowSymlinks: true}
+	zipPath := filepath.Join(dir, "ok.zip")
+	writeTestZipSymlinks(t, zipPath, [][2]string{
+		{"sub/a", "../b.txt"},
+		{"c", "sub"},
+	})
+	dstDir := filepath.Join(dir, "dst")
+	err = ExtractZip(dstDir, zipPath, opts)
+	assert.Nil(t, err)
+	link, err := os.Readlink(filepath.Join(dstDir, "sub", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, "../b.txt", link)
+
+	// symlinks are rejected by default
+	err = ExtractZip(filepath.Join(dir, "dst1"), zipPath, nil)
+	assert.Error(t, err)
+
+	tests := [][][2]string{
+		{{"a", "/etc/passwd"}},
+		{{"a", "../.."}},
+		{{"sub/a", "../../x"}},
+		// y would resolve to parent of dstDir through x
+		{{"x", "."}, {"y", "x/.."}},
+		{{"x", "sub"}, {"y", "x/../../.."}},
+	}
+	for i, links := range tests {
+		evilPath := filepath.Join(dir, "evil.zip")
+		writeTestZipSymlinks(t, evilPath, links)
+		dst := filepath.Join(dir, "dst-evil-"+strconv.Itoa(i))
+		err = ExtractZip(dst, evilPath, opts)
+		assert.Error(t, err, "%v", links)
+		_, err = os.Lstat(filepath.Join(dst, "y"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	zipPath := filepath.Join(dir, "ok.zip")
+	writeTestZip(t, zipPath, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+	})
+	dstDir := filepath.Join(dir, "dst")
+	err = ExtractZip(dstDir, zipPath, nil)
+	assert.Nil(t, err)
+	d, err := ioutil.ReadFile(filepath.Join(dstDir, "sub", "b.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bb", string(d))
+
+	opts := &ExtractZipOptions{MaxTotalSize: 2}
+	err = ExtractZip(filepath.Join(dir, "dst2"), zipPath, opts)
+	assert.Error(t, err)
+	opts = &ExtractZipOptions{MaxEntries: 1}
+	err = ExtractZip(filepath.Join(dir, "dst2"), zipPath, opts)
+	assert.Error(t, err)
+
+	evilPath := filepath.Join(dir, "evil.zip")
+	writeTestZip(t, evilPath, map[string]string{
+		"../evil.txt": "evil",
+	})
+	err = ExtractZip(filepath.Join(dir, "dst3"), evilPath, nil)
+	assert.Error(t, err)
+	assert.False(t, FileExists(filepath.Join(dir, "evil.txt")))
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	zipPath := filepath.Join(dir, "ok.zip")
+	writeTestZip(t, zipPath, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+	})
+	saved := DefaultExtractZipOptions
+	defer func() {
+		DefaultExtractZipOptions = saved
+	}()
+	DefaultExtractZipOptions.MaxEntries = 1
+	// 0 means the default limit
+	opts := &ExtractZipOptions{AllowSymlinks: true}
+	err = ExtractZip(filepath.Join(dir, "dst1"), zipPath, opts)
+	assert.Error(t, err)
+	// < 0 means no limit
+	opts.MaxEntries = -1
+	err = ExtractZip(filepath.Join(dir, "dst2"), zipPath, opts)
+	assert.Nil(t, err)
+
+	DefaultExtractZipOptions.MaxTotalSize = 2
+	opts.MaxTotalSize = 0
+	err = ExtractZip(filepath.Join(dir, "dst3"), zipPath, opts)
+	assert.Error(t, err)
+	opts.MaxTotalSize = -1
+	err = ExtractZip(filepath.Join(dir, "dst4"), zipPath, opts)
+	assert.Nil(t, err)
+}
+
+func TestExtractZipReadOnlyDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "u-zip-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	zipPath := filepath.Join(dir, "ro.zip")
+	f, err := os.Create(zipPath)
+	assert.Nil(t, err)
+	zw := zip.NewWriter(f)
+	fh := &zip.FileHeader{Name: "ro/"}
+	fh.SetMode(os.ModeDir | 0555)
+	_, err = zw.CreateHeader(fh)
+	assert.Nil(t, err)
+	w, err := zw.Create("ro/f.txt")
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, f.Close())
+
+	dstDir := filepath.Join(dir, "dst")
+	err = ExtractZip(dstDir, zipPath, nil)
+	assert.Nil(t, err)
+	defer os.Chmod(filepath.Join(dstDir, "ro"), 0755)
+	d, err := ioutil.ReadFile(filepath.Join(dstDir, "ro", "f.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "f", string(d))
+	fi, err := os.Stat(filepath.Join(dstDir, "ro"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0555), fi.Mode().Perm())
+}